@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/prometheus/common/log"
+)
+
+// logoutPage ends the web session on TC4400.
+const logoutPage = "logout.html"
+
+// readPassword reads a password file, ignoring a trailing line break.
+func readPassword(filename string) (string, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// ReloadPassword re-reads Options.PasswordFile so a rotated password is
+// used from the next request on. The old password is kept on errors.
+func (e *Exporter) ReloadPassword() error {
+	if e.options.PasswordFile == "" {
+		return nil
+	}
+	password, err := readPassword(e.options.PasswordFile)
+	if err != nil {
+		return err
+	}
+	e.mutex.Lock()
+	e.password = password
+	e.mutex.Unlock()
+	return nil
+}
+
+// setAuth sets basic auth from the password file on a request, keeping the
+// user name of the scrape URI.
+func (e *Exporter) setAuth(req *http.Request, u *url.URL) {
+	if e.options.PasswordFile == "" {
+		return
+	}
+	req.SetBasicAuth(u.User.Username(), e.password)
+}
+
+// credentials returns the username and password requests to u are made
+// with, to tell whether they changed.
+func (e *Exporter) credentials(u *url.URL) string {
+	if e.options.PasswordFile != "" {
+		return u.User.Username() + ":" + e.password
+	}
+	return u.User.String()
+}
+
+// logout requests the logout page to release the web session, as TC4400
+// allows only one at a time. Failures are only logged.
+func (e *Exporter) logout() {
+	u, err := pageURL(e.baseURL, logoutPage)
+	if err != nil {
+		log.Warnln("Logging out of TC4400 failed:", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), e.requestTimeout())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		log.Warnln("Logging out of TC4400 failed:", err)
+		return
+	}
+	e.setAuth(req, u)
+
+	e.requests++
+	resp, err := e.client.Do(req)
+	if err != nil {
+		log.Warnln("Logging out of TC4400 failed:", err)
+		return
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		log.Warnln("Logging out of TC4400 failed: HTTP status", resp.StatusCode)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestReadPassword(t *testing.T) {
+	dir := t.TempDir()
+	for content, expected := range map[string]string{
+		"secret":        "secret",
+		"secret\n":      "secret",
+		"secret\r\n":    "secret",
+		" with spaces ": " with spaces ",
+		"":              "",
+	} {
+		filename := filepath.Join(dir, "password")
+		if err := ioutil.WriteFile(filename, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		got, err := readPassword(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != expected {
+			t.Errorf("%q: expected %q, got %q", content, expected, got)
+		}
+	}
+	if _, err := readPassword(filepath.Join(dir, "missing")); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+}
+
+func TestPasswordFile(t *testing.T) {
+	var mutex sync.Mutex
+	var credentials []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, _ := r.BasicAuth()
+		mutex.Lock()
+		credentials = append(credentials, user+":"+password)
+		mutex.Unlock()
+		w.Write(fixturePage)
+	}))
+	defer server.Close()
+	// lastCredentials returns the credentials of the last request.
+	lastCredentials := func() string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return credentials[len(credentials)-1]
+	}
+
+	filename := filepath.Join(t.TempDir(), "password")
+	if err := ioutil.WriteFile(filename, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	options := defaultOptions()
+	options.CollectStatsifc = false
+	options.PasswordFile = filename
+	// The user name is taken from the URI, its password is replaced.
+	e := newTestExporter(t, strings.Replace(server.URL, "://", "://admin:ignored@", 1), options)
+	series(t, e)
+	if got := lastCredentials(); got != "admin:secret" {
+		t.Errorf("Expected admin:secret, got %s", got)
+	}
+
+	// A rotated password is used after reloading, as on SIGHUP.
+	if err := ioutil.WriteFile(filename, []byte("rotated\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	series(t, e)
+	if got := lastCredentials(); got != "admin:secret" {
+		t.Errorf("Expected the password to be kept until reloading, got %s", got)
+	}
+	if err := e.ReloadPassword(); err != nil {
+		t.Fatal(err)
+	}
+	series(t, e)
+	if got := lastCredentials(); got != "admin:rotated" {
+		t.Errorf("Expected admin:rotated, got %s", got)
+	}
+
+	// The password in use is kept if the file can't be read.
+	if err := os.Remove(filename); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.ReloadPassword(); err == nil {
+		t.Error("Expected an error for a missing password file")
+	}
+	series(t, e)
+	if got := lastCredentials(); got != "admin:rotated" {
+		t.Errorf("Expected admin:rotated to be kept, got %s", got)
+	}
+
+	options.PasswordFile = filepath.Join(t.TempDir(), "missing")
+	if _, err := NewExporter(server.URL, time.Second, options); err == nil {
+		t.Error("Expected an error for a missing password file at startup")
+	}
+}
+
+func TestAuthRetry(t *testing.T) {
+	const (
+		status = "cmconnectionstatus.html"
+		stats  = "statsifc.html"
+	)
+	m := newModem(t, map[string]string{
+		status: string(fixturePage),
+		stats:  readTestdata(t, "statsifc.html"),
+	})
+	filename := filepath.Join(t.TempDir(), "password")
+	if err := ioutil.WriteFile(filename, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	options := defaultOptions()
+	options.PasswordFile = filename
+	e := newTestExporter(t, strings.Replace(m.URL, "://", "://admin@", 1), options)
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(e)
+
+	for _, test := range []struct {
+		name string
+		// challenges are the 401 responses per file, password a new
+		// password to reload before the scrape, if any.
+		challenges map[string]int
+		password   string
+		// requests are the expected requests per file, fetched whether
+		// each page was fetched in the end.
+		requests map[string]int
+		fetched  map[string]bool
+	}{
+		{"no challenge", nil, "", map[string]int{stats: 1, status: 1}, map[string]bool{stats: true, status: true}},
+		// statsifc.html is fetched first.
+		{"retry", map[string]int{stats: 1}, "", map[string]int{stats: 2, status: 1}, map[string]bool{stats: true, status: true}},
+		{"one retry per scrape", map[string]int{stats: 1, status: 1}, "", map[string]int{stats: 2, status: 1}, map[string]bool{stats: true, status: false}},
+		{"rejected retry", map[string]int{stats: 2, status: 1}, "", map[string]int{stats: 2, status: 1}, map[string]bool{stats: false, status: false}},
+		{"rejected credentials", map[string]int{stats: 1, status: 1}, "", map[string]int{stats: 1, status: 1}, map[string]bool{stats: false, status: false}},
+		{"accepted again", nil, "", map[string]int{stats: 1, status: 1}, map[string]bool{stats: true, status: true}},
+		{"retry after acceptance", map[string]int{stats: 1}, "", map[string]int{stats: 2, status: 1}, map[string]bool{stats: true, status: true}},
+		{"rejected retry again", map[string]int{stats: 2, status: 1}, "", map[string]int{stats: 2, status: 1}, map[string]bool{stats: false, status: false}},
+		{"changed credentials", map[string]int{stats: 1, status: 1}, "rotated", map[string]int{stats: 2, status: 1}, map[string]bool{stats: true, status: false}},
+	} {
+		if test.password != "" {
+			if err := ioutil.WriteFile(filename, []byte(test.password+"\n"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := e.ReloadPassword(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		before := map[string]int{}
+		for file := range test.requests {
+			m.challenge(file, test.challenges[file])
+			before[file] = m.requestCount(file)
+		}
+		got := gatherSeries(t, registry)
+		for file, expected := range test.requests {
+			if requests := m.requestCount(file) - before[file]; requests != expected {
+				t.Errorf("%s: expected %d requests of %s, got %d", test.name, expected, file, requests)
+			}
+		}
+		if fetched := len(withPrefix(got, "tc4400_downstream_locked{")) > 0; fetched != test.fetched[status] {
+			t.Errorf("%s: expected %s fetched %t, got %t", test.name, status, test.fetched[status], fetched)
+		}
+		if fetched := len(withPrefix(got, "tc4400_network_receive_bytes_total{")) > 0; fetched != test.fetched[stats] {
+			t.Errorf("%s: expected %s fetched %t, got %t", test.name, stats, test.fetched[stats], fetched)
+		}
+	}
+}
+
+func TestLogoutAfterScrape(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		logout bool
+		// page whether the modem serves the logout page.
+		page     bool
+		expected int
+	}{
+		{"disabled", false, true, 0},
+		{"enabled", true, true, 1},
+		// A failed logout doesn't fail the scrape.
+		{"logout page missing", true, false, 1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			pages := map[string]string{
+				"cmconnectionstatus.html": string(fixturePage),
+				"statsifc.html":           readTestdata(t, "statsifc.html"),
+			}
+			if test.page {
+				pages[logoutPage] = htmlPage("")
+			}
+			m := newModem(t, pages)
+			// Record the order of the requests and the credentials of
+			// the logout request.
+			var mutex sync.Mutex
+			files, username := []string{}, ""
+			m.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mutex.Lock()
+				files = append(files, strings.TrimPrefix(r.URL.Path, "/"))
+				if r.URL.Path == "/"+logoutPage {
+					username, _, _ = r.BasicAuth()
+				}
+				mutex.Unlock()
+				m.serve(w, r)
+			})
+
+			options := defaultOptions()
+			options.LogoutAfterScrape = test.logout
+			registry := prometheus.NewPedanticRegistry()
+			registry.MustRegister(newTestExporter(t, strings.Replace(m.URL, "://", "://admin:secret@", 1), options))
+			for scrape := 1; scrape <= 2; scrape++ {
+				got := gatherSeries(t, registry)
+				if up := got["tc4400_up"]; up != 1 {
+					t.Errorf("Scrape %d: expected up 1, got %g", scrape, up)
+				}
+				if requests := m.requestCount(logoutPage); requests != scrape*test.expected {
+					t.Errorf("Scrape %d: expected %d logout requests, got %d", scrape, scrape*test.expected, requests)
+				}
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			if test.expected == 0 {
+				return
+			}
+			if last := files[len(files)-1]; last != logoutPage {
+				t.Errorf("Expected the logout as last request, got %v", files)
+			}
+			if username != "admin" {
+				t.Errorf("Expected the logout with the credentials of the scrape, got user %q", username)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ClientConfig holds client settings read from a file, e.g. a mounted
+// secret, so they don't have to be passed on the command line. Empty fields
+// are ignored.
+type ClientConfig struct {
+	ScrapeURI string        `yaml:"scrape_uri"`
+	Username  string        `yaml:"username"`
+	Password  string        `yaml:"password"`
+	Timeout   time.Duration `yaml:"timeout"`
+}
+
+// LoadClientConfig reads and validates a client config file. As JSON is
+// valid YAML, the file may be either.
+func LoadClientConfig(filename string) (*ClientConfig, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	config := &ClientConfig{}
+	if err := yaml.UnmarshalStrict(content, config); err != nil {
+		return nil, fmt.Errorf("Parsing %s failed: %v", filename, err)
+	}
+	if config.Timeout < 0 {
+		return nil, fmt.Errorf("Negative timeout %s in %s", config.Timeout, filename)
+	}
+	if config.ScrapeURI != "" {
+		for _, uri := range strings.Split(config.ScrapeURI, ",") {
+			if u, err := url.Parse(uri); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return nil, fmt.Errorf("Invalid scrape URI in %s", filename)
+			}
+		}
+	}
+	return config, nil
+}
+
+// apply returns the scrape URI and timeout to use given those of the flags.
+// Flags set explicitly take precedence over the respective fields: a
+// --client.scrape-uri replaces scrape_uri, but gets the configured
+// credentials unless it has its own, and --client.timeout replaces timeout.
+func (c *ClientConfig) apply(scrapeURI string, scrapeURISet bool, timeout time.Duration, timeoutSet bool) (string, time.Duration, error) {
+	if !scrapeURISet && c.ScrapeURI != "" {
+		scrapeURI = c.ScrapeURI
+	}
+	scrapeURI, err := c.withCredentials(scrapeURI, scrapeURISet)
+	if err != nil {
+		return "", 0, err
+	}
+	if !timeoutSet && c.Timeout > 0 {
+		timeout = c.Timeout
+	}
+	return scrapeURI, timeout, nil
+}
+
+// withCredentials sets the configured user name and password on all comma
+// separated URIs. Those of a URI are kept if not configured, or if
+// keepExisting is set.
+func (c *ClientConfig) withCredentials(uris string, keepExisting bool) (string, error) {
+	if c.Username == "" && c.Password == "" {
+		return uris, nil
+	}
+	list := strings.Split(uris, ",")
+	for i, uri := range list {
+		u, err := url.Parse(uri)
+		if err != nil {
+			return "", err
+		}
+		username, password := c.Username, c.Password
+		if u.User != nil && (username == "" || keepExisting) {
+			username = u.User.Username()
+		}
+		if existing, ok := u.User.Password(); ok && (password == "" || keepExisting) {
+			password = existing
+		}
+		u.User = url.UserPassword(username, password)
+		list[i] = u.String()
+	}
+	return strings.Join(list, ","), nil
+}
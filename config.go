@@ -0,0 +1,427 @@
+package main
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+)
+
+//go:embed default-config.yml
+var defaultConfig []byte
+
+// Config describes the pages to scrape and how their tables map to metrics.
+type Config struct {
+	Pages []PageConfig `yaml:"pages"`
+}
+
+// PageConfig selects a page and its tables. Stream parses the page row by
+// row instead of building its whole DOM, for large pages like diagnostics.
+type PageConfig struct {
+	File   string        `yaml:"file"`
+	Stream bool          `yaml:"stream"`
+	Tables []TableConfig `yaml:"tables"`
+}
+
+// TableConfig selects a table on a page by index and maps its rows to
+// metrics. Rows before SkipRows and rows with a cell count other than
+// Columns are ignored.
+type TableConfig struct {
+	Index    int            `yaml:"index"`
+	SkipRows int            `yaml:"skip_rows"`
+	Columns  int            `yaml:"columns"`
+	Labels   []LabelConfig  `yaml:"labels"`
+	Metrics  []MetricConfig `yaml:"metrics"`
+}
+
+// LabelConfig adds a column as a label to every metric of a table. If
+// Format is set the cell is parsed as an integer and formatted with it.
+type LabelConfig struct {
+	Name   string `yaml:"name"`
+	Column int    `yaml:"column"`
+	Format string `yaml:"format"`
+}
+
+// MetricConfig maps a column to a metric. Value selects how the cell is
+// parsed: "int", "raw_float", "bool_match" (1 if the cell equals Match, else
+// 0), "label" (the cell becomes label Label, value 1), "unit_scale" (a number
+// followed by one of Units, scaled by the unit's factor), "qam_parse" (the
+// order of a QAM modulation like "256QAM") or "percent" (a number followed by
+// %, exported as ratio unless Options.RawPercent is set).
+type MetricConfig struct {
+	Name   string             `yaml:"name"`
+	Help   string             `yaml:"help"`
+	Column int                `yaml:"column"`
+	Type   string             `yaml:"type"`
+	Value  string             `yaml:"value"`
+	Match  string             `yaml:"match"`
+	Label  string             `yaml:"label"`
+	Units  map[string]float64 `yaml:"units"`
+}
+
+// errSkipValue makes a value parser skip a cell without counting a parse
+// error.
+var errSkipValue = errors.New("Skip value")
+
+// valueParsers parse a cell into a metric value, keyed by MetricConfig.Value.
+var valueParsers = map[string]func(m MetricConfig, cell string) (float64, error){
+	"int": func(m MetricConfig, cell string) (float64, error) {
+		value, err := parseNumericCell(cell)
+		if err == errAbsent {
+			return 0, errSkipValue
+		}
+		return float64(value), err
+	},
+	"raw_float": func(m MetricConfig, cell string) (float64, error) {
+		return strconv.ParseFloat(cell, 64)
+	},
+	"bool_match": func(m MetricConfig, cell string) (float64, error) {
+		if cell == m.Match {
+			return 1, nil
+		}
+		return 0, nil
+	},
+	"label": func(m MetricConfig, cell string) (float64, error) {
+		return 1, nil
+	},
+	"unit_scale": func(m MetricConfig, cell string) (float64, error) {
+		number, unit := splitValueUnit(cell)
+		factor, ok := m.Units[unit]
+		if number == "" || !ok {
+			return 0, errSkipValue
+		}
+		value, err := strconv.ParseFloat(number, 64)
+		return value * factor, err
+	},
+	"qam_parse": func(m MetricConfig, cell string) (float64, error) {
+		return parseQAM(cell)
+	},
+	"percent": func(m MetricConfig, cell string) (float64, error) {
+		return parsePercent(cell)
+	},
+}
+
+// valueAliases are the former names of values, still accepted in configs.
+var valueAliases = map[string]string{
+	"float": "raw_float",
+	"match": "bool_match",
+	"unit":  "unit_scale",
+	"qam":   "qam_parse",
+}
+
+// parseQAM returns the order of a QAM modulation like "256QAM" or "QAM256".
+// Other modulations are skipped.
+func parseQAM(s string) (float64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if !strings.Contains(s, "QAM") {
+		return 0, errSkipValue
+	}
+	return strconv.ParseFloat(strings.TrimSpace(strings.Replace(s, "QAM", "", 1)), 64)
+}
+
+// LoadConfig reads and validates a config file.
+func LoadConfig(filename string) (*Config, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return parseConfig(content)
+}
+
+func parseConfig(content []byte) (*Config, error) {
+	config := &Config{}
+	if err := yaml.UnmarshalStrict(content, config); err != nil {
+		return nil, err
+	}
+	for _, p := range config.Pages {
+		for _, t := range p.Tables {
+			for i, m := range t.Metrics {
+				if value, ok := valueAliases[m.Value]; ok {
+					t.Metrics[i].Value = value
+				}
+			}
+		}
+	}
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (c *Config) validate() error {
+	// The label names of each metric, metrics of the same name must have
+	// the same labels.
+	metricLabels := map[string]string{}
+	for _, p := range c.Pages {
+		if p.File == "" {
+			return fmt.Errorf("Page without file")
+		}
+		for _, t := range p.Tables {
+			labelNames := []string{}
+			for _, l := range t.Labels {
+				if !isValidLabelName(l.Name) || l.Column < 0 || (t.Columns > 0 && l.Column >= t.Columns) {
+					return fmt.Errorf("Invalid label %q on %s table %d", l.Name, p.File, t.Index)
+				}
+				labelNames = append(labelNames, l.Name)
+			}
+			for _, m := range t.Metrics {
+				if !model.IsValidMetricName(model.LabelValue(m.Name)) || m.Column < 0 || (t.Columns > 0 && m.Column >= t.Columns) {
+					return fmt.Errorf("Invalid metric %q on %s table %d", m.Name, p.File, t.Index)
+				}
+				if m.Name == "up" || strings.HasPrefix(m.Name, "exporter_") {
+					return fmt.Errorf("Metric %s clashes with the metrics of the exporter itself", m.Name)
+				}
+				switch m.Type {
+				case "", "gauge", "counter":
+				default:
+					return fmt.Errorf("Invalid type %q for metric %s", m.Type, m.Name)
+				}
+				if _, ok := valueParsers[m.Value]; !ok {
+					return fmt.Errorf("Invalid value %q for metric %s", m.Value, m.Name)
+				}
+				switch m.Value {
+				case "label":
+					if m.Label == "" {
+						return fmt.Errorf("Metric %s needs a label name", m.Name)
+					}
+				case "unit_scale":
+					if len(m.Units) == 0 {
+						return fmt.Errorf("Metric %s needs units", m.Name)
+					}
+				}
+				names := labelNames
+				if m.Value == "label" {
+					if !isValidLabelName(m.Label) {
+						return fmt.Errorf("Invalid label %q for metric %s", m.Label, m.Name)
+					}
+					names = append(append([]string{}, labelNames...), m.Label)
+				}
+				sorted := append([]string{}, names...)
+				sort.Strings(sorted)
+				for i := 1; i < len(sorted); i++ {
+					if sorted[i] == sorted[i-1] {
+						return fmt.Errorf("Duplicate label %q for metric %s", sorted[i], m.Name)
+					}
+				}
+				key := strings.Join(sorted, ",")
+				if previous, ok := metricLabels[m.Name]; ok && previous != key {
+					return fmt.Errorf("Metric %s has labels [%s] and [%s]", m.Name, previous, key)
+				}
+				metricLabels[m.Name] = key
+			}
+		}
+	}
+	return nil
+}
+
+// isValidLabelName returns whether name is a valid label name that is not
+// reserved for internal use.
+func isValidLabelName(name string) bool {
+	return model.LabelName(name).IsValid() && !strings.HasPrefix(name, "__")
+}
+
+type configMetric struct {
+	MetricConfig
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+}
+
+type configTable struct {
+	TableConfig
+	metrics []configMetric
+}
+
+type configPage struct {
+	file   string
+	stream bool
+	tables []configTable
+}
+
+// newConfigPages builds the metric descriptors for a config.
+func newConfigPages(config *Config, namespace string, constLabels prometheus.Labels) []configPage {
+	pages := []configPage{}
+	for _, p := range config.Pages {
+		page := configPage{file: p.File, stream: p.Stream}
+		for _, t := range p.Tables {
+			table := configTable{TableConfig: t}
+			labelNames := []string{}
+			for _, l := range t.Labels {
+				labelNames = append(labelNames, l.Name)
+			}
+			for _, m := range t.Metrics {
+				metricLabelNames := labelNames
+				if m.Value == "label" {
+					metricLabelNames = append(append([]string{}, labelNames...), m.Label)
+				}
+				valueType := prometheus.GaugeValue
+				if m.Type == "counter" {
+					valueType = prometheus.CounterValue
+				}
+				table.metrics = append(table.metrics, configMetric{
+					MetricConfig: m,
+					desc:         prometheus.NewDesc(prometheus.BuildFQName(namespace, "", m.Name), m.Help, metricLabelNames, constLabels),
+					valueType:    valueType,
+				})
+			}
+			page.tables = append(page.tables, table)
+		}
+		pages = append(pages, page)
+	}
+	return pages
+}
+
+// ApplyConfig replaces the config used from the next scrape on.
+func (e *Exporter) ApplyConfig(config *Config) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.options.Config = config
+	e.configPages = newConfigPages(config, e.options.Namespace, e.constLabels())
+}
+
+// scrapeConfig fetches every configured page and emits the metrics
+// described by its tables.
+func (e *Exporter) scrapeConfig(ch chan<- prometheus.Metric) {
+	for _, page := range e.configPages {
+		body, err := e.fetch(page.file)
+		if err != nil {
+			continue
+		}
+		if page.stream {
+			e.scrapeConfigStream(ch, page, body)
+			body.Close()
+			continue
+		}
+		tables, _, err := e.parseTables(page.file, body)
+		body.Close()
+		if err != nil {
+			e.parseFailed(&ParseError{page.file, -1, -1, -1, parseReasonHTML, err})
+			continue
+		}
+
+		for _, table := range page.tables {
+			if len(tables) <= table.Index || len(tables[table.Index]) < table.SkipRows {
+				e.parseFailed(&ParseError{page.file, table.Index, -1, -1, parseReasonTable, errors.New("Table not found")})
+				continue
+			}
+			for r, row := range tables[table.Index][table.SkipRows:] {
+				if table.Columns > 0 && len(row) != table.Columns {
+					e.rowSkipped(page.file, table.Index)
+					continue
+				}
+				e.emitConfigRow(ch, &ParseError{page.file, table.Index, r + table.SkipRows, -1, parseReasonValue, nil}, table, row)
+			}
+		}
+	}
+}
+
+// scrapeConfigStream emits the metrics of a page with Stream set while its
+// rows are parsed.
+func (e *Exporter) scrapeConfigStream(ch chan<- prometheus.Metric, page configPage, body io.Reader) {
+	start := time.Now()
+	rows := map[int]int{}
+	err := parseTableStream(body, func(index, r int, row []string) {
+		rows[index] = r + 1
+		for _, table := range page.tables {
+			if table.Index != index || r < table.SkipRows {
+				continue
+			}
+			if table.Columns > 0 && len(row) != table.Columns {
+				e.rowSkipped(page.file, table.Index)
+				continue
+			}
+			e.emitConfigRow(ch, &ParseError{page.file, table.Index, r, -1, parseReasonValue, nil}, table, row)
+		}
+	})
+	e.parseDuration.WithLabelValues(page.file).Observe(time.Since(start).Seconds())
+	if err != nil {
+		e.parseFailed(&ParseError{page.file, -1, -1, -1, parseReasonHTML, err})
+		return
+	}
+
+	for _, table := range page.tables {
+		if n, found := rows[table.Index]; !found || n < table.SkipRows {
+			e.parseFailed(&ParseError{page.file, table.Index, -1, -1, parseReasonTable, errors.New("Table not found")})
+		}
+	}
+}
+
+// emitConfigRow emits the metrics of a table row. location holds the file,
+// table and row for errors.
+func (e *Exporter) emitConfigRow(ch chan<- prometheus.Metric, location *ParseError, table configTable, row []string) {
+	failed := func(column int, err error) {
+		parseErr := *location
+		parseErr.Column, parseErr.Err = column, err
+		e.parseFailed(&parseErr)
+	}
+
+	labelValues := []string{}
+	for _, l := range table.Labels {
+		if l.Column >= len(row) {
+			failed(l.Column, errors.New("Label column out of range"))
+			return
+		}
+		value := row[l.Column]
+		if l.Format != "" {
+			valueInt, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				failed(l.Column, err)
+				return
+			}
+			value = fmt.Sprintf(l.Format, valueInt)
+		}
+		labelValues = append(labelValues, value)
+	}
+
+	for _, m := range table.metrics {
+		if m.Column >= len(row) {
+			continue
+		}
+		cell := row[m.Column]
+		metricLabelValues := labelValues
+		parseCell := cell
+		switch m.Value {
+		case "raw_float", "unit_scale", "percent":
+			parseCell = e.decimal(cell)
+		}
+		value, err := valueParsers[m.Value](m.MetricConfig, parseCell)
+		if err == errSkipValue {
+			continue
+		}
+		if err != nil {
+			failed(m.Column, err)
+			continue
+		}
+		switch m.Value {
+		case "label":
+			metricLabelValues = append(append([]string{}, labelValues...), cell)
+		case "percent":
+			if e.options.RawPercent {
+				value *= 100
+			}
+		}
+		switch m.Value {
+		case "raw_float", "unit_scale", "percent":
+			if m.valueType == prometheus.GaugeValue {
+				value = e.round(value)
+			}
+		}
+		// A config label clashing with a constant label like firmware makes
+		// the descriptor invalid, which fails the scrape instead of the
+		// exporter.
+		metric, err := prometheus.NewConstMetric(m.desc, m.valueType, value, metricLabelValues...)
+		if err != nil {
+			metric = prometheus.NewInvalidMetric(m.desc, err)
+		}
+		ch <- metric
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// configMetricNames returns the fully qualified names of the metrics of a
+// config.
+func configMetricNames(config *Config) []string {
+	names := []string{}
+	for _, p := range config.Pages {
+		for _, t := range p.Tables {
+			for _, m := range t.Metrics {
+				names = append(names, defaultNamespace+"_"+m.Name)
+			}
+		}
+	}
+	return names
+}
+
+func TestDefaultConfig(t *testing.T) {
+	config, err := parseConfig(defaultConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages := map[string]string{
+		"cmconnectionstatus.html": string(fixturePage),
+		"statsifc.html":           readTestdata(t, "statsifc.html"),
+	}
+	builtin := scrapeModem(t, pages, nil)
+	configured := scrapeModem(t, pages, func(o *Options) { o.Config = config })
+
+	// The default config is equivalent to the built-in collector for the
+	// metrics it defines.
+	for _, name := range configMetricNames(config) {
+		expected := withPrefix(builtin, name+"{")
+		if len(expected) == 0 {
+			t.Errorf("Built-in collector emitted no %s", name)
+		}
+		if got := withPrefix(configured, name+"{"); !reflect.DeepEqual(got, expected) {
+			t.Errorf("Expected %s %v, got %v", name, expected, got)
+		}
+	}
+
+	for key, expected := range map[string]float64{
+		`tc4400_network_receive_bytes_total{interface="LAN"}`:      1234567,
+		`tc4400_network_transmit_bytes_total{interface="CM"}`:      84,
+		`tc4400_upstream_transmit_level_dbmv{channel="01"}`:        45,
+		`tc4400_downstream_channel_type{channel="33",type="OFDM"}`: 1,
+	} {
+		if got, ok := configured[key]; !ok || got != expected {
+			t.Errorf("Expected %s %g, got %g (present: %t)", key, expected, got, ok)
+		}
+	}
+}
+
+func TestApplyConfigConstLabels(t *testing.T) {
+	config, err := parseConfig(defaultConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newModem(t, map[string]string{
+		"cmconnectionstatus.html": string(fixturePage),
+		"statsifc.html":           readTestdata(t, "statsifc.html"),
+		// No firmware version, so the label keeps its initial value.
+		"cmswinfo.html": htmlPage(`<table><tr><td>Model Name</td><td>TC4400</td></tr></table>`),
+	})
+	options := defaultOptions()
+	options.FirmwareLabel = true
+	options.Config = config
+	e := newTestExporter(t, m.URL, options)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(e)
+
+	keys := func() []string {
+		keys := []string{}
+		for key := range withPrefix(gatherSeries(t, registry), "tc4400_downstream_locked") {
+			keys = append(keys, key)
+		}
+		return keys
+	}
+	before := keys()
+	if len(before) == 0 || !strings.Contains(before[0], `firmware=""`) {
+		t.Fatalf("Expected config metrics with a firmware label, got %v", before)
+	}
+	e.ApplyConfig(config)
+	if after := keys(); len(after) != len(before) || !strings.Contains(after[0], `firmware=""`) {
+		t.Errorf("Expected the same labels after applying the config again, got %v and %v", before, after)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	// config returns a config of one table with the given labels and
+	// metrics.
+	config := func(labels string, metrics ...string) string {
+		return "pages:\n  - file: cmconnectionstatus.html\n    tables:\n      - index: 1\n        labels: [" + labels + "]\n        metrics:\n          - " + strings.Join(metrics, "\n          - ") + "\n"
+	}
+	locked := "{name: downstream_locked, column: 2, value: bool_match, match: Locked}"
+	for _, test := range []struct {
+		name   string
+		config string
+		valid  bool
+	}{
+		{"valid", config("{name: channel, column: 1}", locked), true},
+		{"label value", config("{name: channel, column: 1}", locked, "{name: downstream_modulation, column: 9, value: label, label: modulation}"), true},
+		{"same labels on several tables", "pages:\n  - file: a.html\n    tables:\n      - {index: 0, labels: [{name: channel, column: 0}], metrics: [{name: m, column: 1, value: int}]}\n      - {index: 1, labels: [{name: channel, column: 0}], metrics: [{name: m, column: 1, value: int}]}\n", true},
+		{"invalid metric name", config("{name: channel, column: 1}", "{name: downstream-locked, column: 2, value: int}"), false},
+		{"metric name starting with a digit", config("{name: channel, column: 1}", "{name: 1locked, column: 2, value: int}"), false},
+		{"empty metric name", config("{name: channel, column: 1}", "{column: 2, value: int}"), false},
+		{"invalid label name", config("{name: channel id, column: 1}", locked), false},
+		{"reserved label name", config("{name: __channel, column: 1}", locked), false},
+		{"invalid label value label", config("{name: channel, column: 1}", "{name: downstream_modulation, column: 9, value: label, label: modulation-id}"), false},
+		{"duplicate label", config("{name: channel, column: 1}", "{name: downstream_channel, column: 1, value: label, label: channel}"), false},
+		{"up", config("{name: channel, column: 1}", "{name: up, column: 2, value: int}"), false},
+		{"exporter metric", config("{name: channel, column: 1}", "{name: exporter_scrapes_total, column: 2, value: int}"), false},
+		{"different labels", config("{name: channel, column: 1}", locked, "{name: downstream_locked, column: 3, value: label, label: type}"), false},
+		{"different labels on several tables", "pages:\n  - file: a.html\n    tables:\n      - {index: 0, labels: [{name: channel, column: 0}], metrics: [{name: m, column: 1, value: int}]}\n      - {index: 1, labels: [{name: interface, column: 0}], metrics: [{name: m, column: 1, value: int}]}\n", false},
+		{"invalid type", config("{name: channel, column: 1}", "{name: downstream_locked, column: 2, type: histogram, value: int}"), false},
+		{"invalid value", config("{name: channel, column: 1}", "{name: downstream_locked, column: 2, value: bool}"), false},
+	} {
+		_, err := parseConfig([]byte(test.config))
+		if (err == nil) != test.valid {
+			t.Errorf("%s: expected valid %t, got error %v", test.name, test.valid, err)
+		}
+	}
+}
+
+func TestConfigLabelClash(t *testing.T) {
+	// A config label named like the constant firmware label fails the
+	// scrape instead of panicking.
+	config, err := parseConfig([]byte("pages:\n  - file: cmconnectionstatus.html\n    tables:\n      - {index: 0, skip_rows: 2, labels: [{name: firmware, column: 1}], metrics: [{name: downstream_locked, help: Downstream Lock Status, column: 2, value: bool_match, match: Locked}]}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newModem(t, map[string]string{"cmconnectionstatus.html": string(fixturePage)})
+	options := defaultOptions()
+	options.FirmwareLabel = true
+	options.Config = config
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(newTestExporter(t, m.URL, options))
+	if _, err := registry.Gather(); err == nil {
+		t.Error("Expected an error gathering a config label clashing with the firmware label")
+	}
+}
+
+func TestConfigPercent(t *testing.T) {
+	config, err := parseConfig([]byte("pages:\n  - file: diagnostics.html\n    tables:\n      - index: 0\n        skip_rows: 2\n        labels: [{name: channel, column: 0}]\n        metrics:\n          - {name: channel_utilization_ratio, help: Channel Utilization, column: 1, value: percent}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages := map[string]string{"diagnostics.html": htmlPage(htmlTable("Utilization", []string{"Channel", "Utilization"},
+		[]string{"1", "12.5 %"},
+		[]string{"2", "0%"},
+		[]string{"3", "100%"},
+		[]string{"4", "n/a"},
+	))}
+	for _, test := range []struct {
+		name     string
+		raw      bool
+		expected map[string]float64
+	}{
+		{"ratio", false, map[string]float64{
+			`tc4400_channel_utilization_ratio{channel="1"}`: 0.125,
+			`tc4400_channel_utilization_ratio{channel="2"}`: 0,
+			`tc4400_channel_utilization_ratio{channel="3"}`: 1,
+		}},
+		{"raw percent", true, map[string]float64{
+			`tc4400_channel_utilization_ratio{channel="1"}`: 12.5,
+			`tc4400_channel_utilization_ratio{channel="2"}`: 0,
+			`tc4400_channel_utilization_ratio{channel="3"}`: 100,
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got := scrapeModem(t, pages, func(o *Options) {
+				o.Config = config
+				o.RawPercent = test.raw
+			})
+			if metrics := withPrefix(got, "tc4400_channel_utilization_ratio"); !reflect.DeepEqual(metrics, test.expected) {
+				t.Errorf("Expected %v, got %v", test.expected, metrics)
+			}
+			// The malformed cell is counted as parse error.
+			if errors := got[`tc4400_exporter_parse_errors_total{file="diagnostics.html",reason="value"}`]; errors != 1 {
+				t.Errorf("Expected one parse error, got %g", errors)
+			}
+		})
+	}
+}
+
+func TestConfigValues(t *testing.T) {
+	// absent marks cells that export no value.
+	absent := math.NaN()
+	for _, test := range []struct {
+		name     string
+		metric   string
+		cells    []string
+		expected []float64
+	}{
+		{"int", "{name: v, column: 1, value: int}", []string{"42", "1,234", "-"}, []float64{42, 1234, absent}},
+		{"raw_float", "{name: v, column: 1, value: raw_float}", []string{"4.5", "-0.25", "1e3"}, []float64{4.5, -0.25, 1000}},
+		{"bool_match", "{name: v, column: 1, value: bool_match, match: Locked}", []string{"Locked", "Not Locked", ""}, []float64{1, 0, 0}},
+		{"unit_scale", "{name: v, column: 1, value: unit_scale, units: {Hz: 1, kHz: 1000, MHz: 1000000}}", []string{"602000000 Hz", "94000kHz", "6.4 MHz", "5 GHz"}, []float64{602000000, 94000000, 6400000, absent}},
+		{"qam_parse", "{name: v, column: 1, value: qam_parse}", []string{"256QAM", "QAM64", "OFDM"}, []float64{256, 64, absent}},
+		{"percent", "{name: v, column: 1, value: percent}", []string{"12.5%", "0 %"}, []float64{0.125, 0}},
+		// The former names are aliases.
+		{"float", "{name: v, column: 1, value: float}", []string{"4.5"}, []float64{4.5}},
+		{"match", "{name: v, column: 1, value: match, match: Locked}", []string{"Locked", "Not Locked"}, []float64{1, 0}},
+		{"unit", "{name: v, column: 1, value: unit, units: {Hz: 1, kHz: 1000}}", []string{"94000kHz"}, []float64{94000000}},
+		{"qam", "{name: v, column: 1, value: qam}", []string{"QAM64"}, []float64{64}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			config, err := parseConfig([]byte("pages:\n  - file: values.html\n    tables:\n      - index: 0\n        skip_rows: 2\n        labels: [{name: row, column: 0}]\n        metrics: [" + test.metric + "]\n"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rows := [][]string{}
+			for i, cell := range test.cells {
+				rows = append(rows, []string{fmt.Sprint(i), cell})
+			}
+			got := scrapeModem(t, map[string]string{"values.html": htmlPage(htmlTable("Values", []string{"Row", "Value"}, rows...))}, func(o *Options) { o.Config = config })
+			for i, expected := range test.expected {
+				key := fmt.Sprintf(`tc4400_v{row="%d"}`, i)
+				value, ok := got[key]
+				if math.IsNaN(expected) {
+					if ok {
+						t.Errorf("%q: expected no value, got %g", test.cells[i], value)
+					}
+					continue
+				}
+				if !ok || value != expected {
+					t.Errorf("%q: expected %g, got %g (present: %t)", test.cells[i], expected, value, ok)
+				}
+			}
+		})
+	}
+
+	// The label value exports the cell as label.
+	config, err := parseConfig([]byte("pages:\n  - file: values.html\n    tables:\n      - {index: 0, skip_rows: 2, labels: [{name: row, column: 0}], metrics: [{name: v, column: 1, value: label, label: modulation}]}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := scrapeModem(t, map[string]string{"values.html": htmlPage(htmlTable("Values", []string{"Row", "Value"}, []string{"0", "256QAM"}))}, func(o *Options) { o.Config = config })
+	if value := got[`tc4400_v{modulation="256QAM",row="0"}`]; value != 1 {
+		t.Errorf("Expected the cell as label, got %v", withPrefix(got, "tc4400_v"))
+	}
+}
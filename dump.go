@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+)
+
+// dumpTables writes every table as CSV, separated by blank lines.
+func dumpTables(w io.Writer, tables [][][]string) error {
+	for i, table := range tables {
+		if i > 0 {
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				return err
+			}
+		}
+		cw := csv.NewWriter(w)
+		if err := cw.WriteAll(table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dumpPage fetches a page from the modem and writes its tables as CSV.
+func (e *Exporter) dumpPage(w io.Writer, filename string) error {
+	body, err := e.fetch(filename)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	tables, err := parseTables(body, e.options.SkipNestedTables)
+	if err != nil {
+		return err
+	}
+	return dumpTables(w, tables)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDumpPage(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		page     string
+		expected string
+	}{
+		{"fixture", string(fixturePage), readTestdata(t, "fixture.csv")},
+		{"quoted", htmlPage(htmlTable("Status", []string{"Key", "Value"}, []string{"Uptime", "1 day, 02:03:04"}, []string{"Note", `"quoted"`})),
+			"Status\nKey,Value\nUptime,\"1 day, 02:03:04\"\nNote,\"\"\"quoted\"\"\"\n"},
+		{"no tables", htmlPage("<p>Not found</p>"), ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			m := newModem(t, map[string]string{"cmconnectionstatus.html": test.page})
+			var b strings.Builder
+			if err := newTestExporter(t, m.URL, defaultOptions()).dumpPage(&b, "cmconnectionstatus.html"); err != nil {
+				t.Fatal(err)
+			}
+			if got := b.String(); got != test.expected {
+				t.Errorf("Expected\n%s\ngot\n%s", test.expected, got)
+			}
+		})
+	}
+
+	m := newModem(t, nil)
+	if err := newTestExporter(t, m.URL, defaultOptions()).dumpPage(&strings.Builder{}, "missing.html"); err == nil {
+		t.Error("Expected an error for a missing page")
+	}
+}
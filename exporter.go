@@ -1,19 +1,33 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"path"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/common/log"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
 )
 
 var (
@@ -21,348 +35,1961 @@ var (
 	interfaceLabelNames = []string{"interface"}
 )
 
-func newChannelMetric(subsystemName, metricName, docString string, extraLabels ...string) *prometheus.Desc {
-	return prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystemName, metricName), docString, append(channelLabelNames, extraLabels...), nil)
+type metrics map[int]*prometheus.Desc
+
+// stringColumn is a table column, found by a keyword of its header, whose
+// raw string value may be exported as a label on an info metric.
+type stringColumn struct {
+	keyword string
+	label   string
 }
 
-type metrics map[int]*prometheus.Desc
+// stringInfoColumns is the allow-list of string columns eligible for
+// export as info metric labels. It only lists columns some firmware adds to
+// the channel tables that aren't exported otherwise, and is kept short to
+// bound cardinality.
+var stringInfoColumns = []stringColumn{
+	{"Annotation", "annotation"},
+	{"Comment", "comment"},
+}
 
-var (
-	targetUpMetric = prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "up"), "Was the last scrape of TC4400 succesful.", nil, nil)
-
-	networkMetrics = metrics{
-		1: prometheus.NewDesc(prometheus.BuildFQName(namespace, "network", "receive_bytes_total"), "", []string{"interface"}, nil),
-		2: prometheus.NewDesc(prometheus.BuildFQName(namespace, "network", "receive_packets_total"), "", []string{"interface"}, nil),
-		3: prometheus.NewDesc(prometheus.BuildFQName(namespace, "network", "receive_errs_total"), "", []string{"interface"}, nil),
-		4: prometheus.NewDesc(prometheus.BuildFQName(namespace, "network", "receive_drop_total"), "", []string{"interface"}, nil),
-		5: prometheus.NewDesc(prometheus.BuildFQName(namespace, "network", "transmit_bytes_total"), "", []string{"interface"}, nil),
-		6: prometheus.NewDesc(prometheus.BuildFQName(namespace, "network", "transmit_packets_total"), "", []string{"interface"}, nil),
-		7: prometheus.NewDesc(prometheus.BuildFQName(namespace, "network", "transmit_errs_total"), "", []string{"interface"}, nil),
-		8: prometheus.NewDesc(prometheus.BuildFQName(namespace, "network", "transmit_drop_total"), "", []string{"interface"}, nil),
-	}
-
-	downstreamChannelMetrics = metrics{
-		2:  newChannelMetric("downstream", "locked", "Downstream Lock Status"),
-		3:  newChannelMetric("downstream", "channel_type", "Downstream Channel Type", "type"),
-		4:  newChannelMetric("downstream", "bonded", "Downstream Bonding Status"),
-		5:  newChannelMetric("downstream", "center_frequency_hz", "Downstream Center Frequency"),
-		6:  newChannelMetric("downstream", "width_hz", "Downstream Width"),
-		7:  newChannelMetric("downstream", "snr_threshold_db", "Downstream SNR/MER Threshold Value"),
-		8:  newChannelMetric("downstream", "receive_level_dbmv", "Downstream Receive Level"),
-		9:  newChannelMetric("downstream", "modulation", "Downstream Modulation/Profile ID", "modulation"),
-		10: newChannelMetric("downstream", "codewords_unerrored_total", "Downstream Unerrored Codewords"),
-		11: newChannelMetric("downstream", "codewords_corrected_total", "Downstream Corrected Codewords"),
-		12: newChannelMetric("downstream", "codewords_uncorrectable_total", "Downstream Uncorrectable Codewords"),
-	}
-
-	upstreamChannelMetrics = metrics{
-		2: newChannelMetric("upstream", "locked", "Upstream Lock Status"),
-		3: newChannelMetric("upstream", "channel_type", "Downstream Channel Type", "type"),
-		4: newChannelMetric("upstream", "bonded", "Upstream Bonding Status"),
-		5: newChannelMetric("upstream", "center_frequency_hz", "Upstream Center Frequency"),
-		6: newChannelMetric("upstream", "width_hz", "Upstream Width"),
-		7: newChannelMetric("upstream", "transmit_level_dbmv", "Upstream Transmit Level"),
-		8: newChannelMetric("upstream", "modulation", "Upstream Modulation/Profile ID", "modulation"),
+func stringInfoLabelNames() []string {
+	labels := []string{}
+	for _, c := range stringInfoColumns {
+		labels = append(labels, c.label)
 	}
-)
+	return labels
+}
+
+// stringInfoLabelValues returns the label values of the string info metric of
+// a channel row and whether the table has any of the string columns.
+func stringInfoLabelValues(channelLabel string, header, row []string) ([]string, bool) {
+	labelValues := []string{channelLabel}
+	found := false
+	for _, c := range stringInfoColumns {
+		value := ""
+		if i := selectColumn(header, c.keyword); i >= 0 && i < len(row) {
+			value, found = row[i], true
+		}
+		labelValues = append(labelValues, value)
+	}
+	return labelValues, found
+}
+
+// descriptors holds the metric descriptors of the built-in collector.
+type descriptors struct {
+	targetUp *prometheus.Desc
+
+	network    metrics
+	downstream metrics
+	upstream   metrics
+
+	provisioningStep              *prometheus.Desc
+	cmIPInfo                      *prometheus.Desc
+	cmTFTPServerInfo              *prometheus.Desc
+	cmCPECount                    *prometheus.Desc
+	deviceTemperature             *prometheus.Desc
+	firmwareUpdatePending         *prometheus.Desc
+	systemResets                  *prometheus.Desc
+	deviceInfo                    *prometheus.Desc
+	provisionedMaxDownstream      *prometheus.Desc
+	provisionedMaxUpstream        *prometheus.Desc
+	cmDHCPLease                   *prometheus.Desc
+	cmDHCPLeaseRemaining          *prometheus.Desc
+	downstreamChannelHealth       *prometheus.Desc
+	signalQualityScore            *prometheus.Desc
+	downstreamPLCLocked           *prometheus.Desc
+	downstreamSNRMargin           *prometheus.Desc
+	downstreamChannelOverlap      *prometheus.Desc
+	downstreamDistinctModulations *prometheus.Desc
+	downstreamReceiveLevels       *prometheus.Desc
+	parserVariant                 *prometheus.Desc
+	downstreamBondedActive        *prometheus.Desc
+	downstreamChannelsByType      *prometheus.Desc
+	downstreamSNRSpread           *prometheus.Desc
+	downstreamLockedRatio         *prometheus.Desc
+	downstreamThroughput          *prometheus.Desc
+	upstreamLockedRatio           *prometheus.Desc
+	downstreamBondingGroupSize    *prometheus.Desc
+	downstreamChannelTypeInfo     *prometheus.Desc
+	downstreamUncorrectableEvents *prometheus.Desc
+	downstreamLockFlaps           *prometheus.Desc
+	downstreamFrequencyChanges    *prometheus.Desc
+	downstreamProfileDowngrades   *prometheus.Desc
+	upstreamTransmitHeadroom      *prometheus.Desc
+	downstreamCounterReset        *prometheus.Desc
+	downstreamFECFailureRatio     *prometheus.Desc
+	downstreamStringInfo          *prometheus.Desc
+	upstreamStringInfo            *prometheus.Desc
+}
+
+func newDescriptors(namespace string, subsystems Subsystems, constLabels prometheus.Labels) *descriptors {
+	subsystems = subsystems.withDefaults()
+	newMetric := func(subsystemName, metricName, docString string, labels []string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystemName, metricName), docString, labels, constLabels)
+	}
+	newChannelMetric := func(subsystemName, metricName, docString string, extraLabels ...string) *prometheus.Desc {
+		return newMetric(subsystemName, metricName, docString, append(channelLabelNames, extraLabels...))
+	}
+
+	return &descriptors{
+		targetUp: newMetric("", "up", "Was the last scrape of TC4400 succesful.", nil),
+
+		network: metrics{
+			1: newMetric(subsystems.Network, "receive_bytes_total", "Network Interface Received Bytes", interfaceLabelNames),
+			2: newMetric(subsystems.Network, "receive_packets_total", "Network Interface Received Packets", interfaceLabelNames),
+			3: newMetric(subsystems.Network, "receive_errs_total", "Network Interface Receive Errors", interfaceLabelNames),
+			4: newMetric(subsystems.Network, "receive_drop_total", "Network Interface Received Packets Dropped", interfaceLabelNames),
+			5: newMetric(subsystems.Network, "transmit_bytes_total", "Network Interface Transmitted Bytes", interfaceLabelNames),
+			6: newMetric(subsystems.Network, "transmit_packets_total", "Network Interface Transmitted Packets", interfaceLabelNames),
+			7: newMetric(subsystems.Network, "transmit_errs_total", "Network Interface Transmit Errors", interfaceLabelNames),
+			8: newMetric(subsystems.Network, "transmit_drop_total", "Network Interface Transmitted Packets Dropped", interfaceLabelNames),
+		},
+
+		downstream: metrics{
+			2:  newChannelMetric(subsystems.Downstream, "locked", "Downstream Lock Status"),
+			3:  newChannelMetric(subsystems.Downstream, "channel_type", "Downstream Channel Type", "type"),
+			4:  newChannelMetric(subsystems.Downstream, "bonded", "Downstream Bonding Status"),
+			5:  newChannelMetric(subsystems.Downstream, "center_frequency_hz", "Downstream Center Frequency"),
+			6:  newChannelMetric(subsystems.Downstream, "width_hz", "Downstream Width"),
+			7:  newChannelMetric(subsystems.Downstream, "snr_threshold_db", "Downstream SNR/MER Threshold Value"),
+			8:  newChannelMetric(subsystems.Downstream, "receive_level_dbmv", "Downstream Receive Level"),
+			9:  newChannelMetric(subsystems.Downstream, "modulation", "Downstream Modulation/Profile ID", "modulation"),
+			10: newChannelMetric(subsystems.Downstream, "codewords_unerrored_total", "Downstream Unerrored Codewords"),
+			11: newChannelMetric(subsystems.Downstream, "codewords_corrected_total", "Downstream Corrected Codewords"),
+			12: newChannelMetric(subsystems.Downstream, "codewords_uncorrectable_total", "Downstream Uncorrectable Codewords"),
+		},
+
+		upstream: metrics{
+			2: newChannelMetric(subsystems.Upstream, "locked", "Upstream Lock Status"),
+			3: newChannelMetric(subsystems.Upstream, "channel_type", "Upstream Channel Type", "type"),
+			4: newChannelMetric(subsystems.Upstream, "bonded", "Upstream Bonding Status"),
+			5: newChannelMetric(subsystems.Upstream, "center_frequency_hz", "Upstream Center Frequency"),
+			6: newChannelMetric(subsystems.Upstream, "width_hz", "Upstream Width"),
+			7: newChannelMetric(subsystems.Upstream, "transmit_level_dbmv", "Upstream Transmit Level"),
+			8: newChannelMetric(subsystems.Upstream, "modulation", "Upstream Modulation/Profile ID", "modulation"),
+		},
+
+		provisioningStep:              newMetric("provisioning", "step", "Startup Procedure Step Status (1 = done, 0 = pending)", []string{"step"}),
+		cmIPInfo:                      newMetric("cm", "ip_info", "Cable Modem IP Address", []string{"ip", "family"}),
+		cmCPECount:                    newMetric("cm", "cpe_count", "Number of CPE attached to the Cable Modem", nil),
+		cmTFTPServerInfo:              newMetric("cm", "tftp_server_info", "TFTP Server of the Cable Modem Boot File", []string{"server"}),
+		cmDHCPLease:                   newMetric("cm", "dhcp_lease_seconds", "Cable Modem DHCP Lease Time", nil),
+		cmDHCPLeaseRemaining:          newMetric("cm", "dhcp_lease_remaining_seconds", "Cable Modem DHCP Lease Time Remaining", nil),
+		systemResets:                  newMetric("system", "resets_total", "Number of TC4400 reboots reported by the device", nil),
+		provisionedMaxDownstream:      newMetric("provisioned", "max_downstream_bps", "Maximum downstream rate provisioned by the CMTS in bits per second", nil),
+		provisionedMaxUpstream:        newMetric("provisioned", "max_upstream_bps", "Maximum upstream rate provisioned by the CMTS in bits per second", nil),
+		deviceInfo:                    newMetric("device", "info", "Device Information as reported by TC4400", deviceInfoLabelNames()),
+		deviceTemperature:             newMetric("device", "temperature_celsius", "Device Temperature", nil),
+		firmwareUpdatePending:         newMetric("firmware", "update_pending", "Whether a Firmware Update is available or in progress", nil),
+		signalQualityScore:            newMetric("signal", "quality_score", "Signal Quality Score (0-100), the weighted mean of the fraction of locked channels, the mean downstream SNR margin above the healthy minimum capped at 10 dB, the fraction of downstream receive levels in range and 1 minus the fraction of uncorrectable codewords", nil),
+		downstreamChannelHealth:       newChannelMetric(subsystems.Downstream, "channel_health", "Downstream Channel Health Score (0-3, one point each for locked, receive level in range and SNR above threshold)"),
+		downstreamChannelTypeInfo:     newChannelMetric(subsystems.Downstream, "channel_type_info", "Downstream Channel Type as info metric keyed by channel", "type"),
+		downstreamSNRMargin:           newChannelMetric(subsystems.Downstream, "snr_margin_db", "Downstream measured SNR/MER above the Threshold Value"),
+		downstreamChannelOverlap:      newMetric(subsystems.Downstream, "channel_overlap", "Number of pairs of Downstream Channels with overlapping frequency ranges", nil),
+		downstreamDistinctModulations: newMetric(subsystems.Downstream, "distinct_modulations", "Number of distinct Modulation/Profile IDs of all Downstream Channels", nil),
+		downstreamBondedActive:        newMetric(subsystems.Downstream, "bonded_active", "Number of Downstream Channels with Bonding Status Bonded", nil),
+		downstreamThroughput:          newMetric(subsystems.Downstream, "theoretical_throughput_bps", "Estimated gross capacity of the locked and bonded Downstream Channels in bit/s: the sum of Width x log2(QAM order) x symbol rate factor, 0.87 for SC-QAM and 0.9 for OFDM, ignoring FEC and protocol overhead", nil),
+		downstreamLockedRatio:         newMetric(subsystems.Downstream, "locked_ratio", "Ratio of locked Downstream Channels to all Downstream Channels", nil),
+		upstreamLockedRatio:           newMetric(subsystems.Upstream, "locked_ratio", "Ratio of locked Upstream Channels to all Upstream Channels", nil),
+		downstreamSNRSpread:           newMetric(subsystems.Downstream, "snr_spread_db", "Difference between the highest and lowest SNR of the locked Downstream Channels", nil),
+		downstreamChannelsByType:      newMetric(subsystems.Downstream, "channels_by_type", "Number of Downstream Channels per Channel Type", []string{"type"}),
+		downstreamBondingGroupSize:    newMetric(subsystems.Downstream, "bonding_group_size", "Number of Downstream Channels the bonding group allows as reported by the device", nil),
+		parserVariant:                 newMetric("exporter", "parser_variant", "Variant of the channel table layout the downstream channels were parsed with", []string{"variant"}),
+		downstreamReceiveLevels:       newMetric(subsystems.Downstream, "receive_level_dbmv_distribution", "Distribution of the Downstream Receive Level of locked channels in the last scrape", nil),
+		downstreamPLCLocked:           newChannelMetric(subsystems.Downstream, "plc_locked", "Downstream OFDM PLC Lock Status"),
+		downstreamUncorrectableEvents: newChannelMetric(subsystems.Downstream, "uncorrectable_events_total", "Number of scrapes in which the Downstream Uncorrectable Codewords increased"),
+		downstreamLockFlaps:           newChannelMetric(subsystems.Downstream, "lock_flaps_total", "Number of times the Downstream Lock Status changed between scrapes"),
+		downstreamFrequencyChanges:    newChannelMetric(subsystems.Downstream, "frequency_changes_total", "Number of times the Downstream Center Frequency changed between scrapes"),
+		downstreamProfileDowngrades:   newChannelMetric(subsystems.Downstream, "profile_downgrades_total", "Number of times the Profile ID or Modulation of an OFDM Downstream Channel decreased between scrapes"),
+		upstreamTransmitHeadroom:      newChannelMetric(subsystems.Upstream, "transmit_headroom_db", "Difference between the maximum transmit level and the Upstream Transmit Level"),
+		downstreamCounterReset:        newChannelMetric(subsystems.Downstream, "counter_reset_timestamp_seconds", "Time a decrease of the Downstream Codeword counters was last observed"),
+		downstreamFECFailureRatio:     newChannelMetric(subsystems.Downstream, "fec_failure_ratio", "Ratio of Downstream Uncorrectable to Corrected and Uncorrectable Codewords since the channel was first seen"),
+		downstreamStringInfo:          newChannelMetric(subsystems.Downstream, "status_info", "Downstream Channel columns not exported otherwise, like annotations, as reported by TC4400", stringInfoLabelNames()...),
+		upstreamStringInfo:            newChannelMetric(subsystems.Upstream, "status_info", "Upstream Channel columns not exported otherwise, like annotations, as reported by TC4400", stringInfoLabelNames()...),
+	}
+}
+
+// Options holds optional collector settings.
+type Options struct {
+	ChannelHealth         bool
+	HealthMinReceiveLevel float64
+	HealthMaxReceiveLevel float64
+	HealthMinSNR          float64
+
+	// QualityScore exports a signal quality score computed from all
+	// channels, weighing its components by QualityWeights.
+	QualityScore     bool
+	QualityWeights   QualityWeights
+	StringInfo       bool
+	InterfaceRenames map[string]string
+	TimeoutJitter    time.Duration
+	DurationBuckets  []float64
+	Namespace        string
+
+	// CollectStatsifc, CollectCMConnectionStatus and CollectCMSWInfo enable
+	// fetching the respective pages. DeviceInfo fetches cmswinfo.html too.
+	CollectStatsifc           bool
+	CollectCMConnectionStatus bool
+	CollectCMSWInfo           bool
+
+	// DeviceInfo exports the device information of cmswinfo.html like
+	// model and serial number as labels of an info metric.
+	DeviceInfo bool
+
+	// DownstreamTableIndex and UpstreamTableIndex select the channel tables
+	// on cmconnectionstatus.html by index instead of by title if >= 0.
+	DownstreamTableIndex int
+	UpstreamTableIndex   int
+
+	// MinDownstreamChannels and MinUpstreamChannels make a scrape report
+	// TC4400 as down if fewer channels were parsed.
+	MinDownstreamChannels int
+	MinUpstreamChannels   int
+
+	// Config replaces the built-in page parsing if set.
+	Config *Config
+
+	// PasswordFile replaces the password of the scrape URI with the content
+	// of the file if set.
+	PasswordFile string
+
+	// CollectorTimeout bounds a whole scrape if > 0. Requests still
+	// pending are canceled and TC4400 is reported as down.
+	CollectorTimeout time.Duration
+
+	// SelfTargetLabel adds the host of the scrape URI as target label to the
+	// exporter's own metrics.
+	SelfTargetLabel bool
+
+	// RawPercent exports percentages from 0 to 100 instead of ratios.
+	RawPercent bool
+
+	// MaxChannels limits the number of downstream and upstream channels
+	// exported per scrape if > 0.
+	MaxChannels int
+
+	// MaxInterfaces limits the number of network interfaces exported per
+	// scrape if > 0.
+	MaxInterfaces int
+
+	// FirmwareLabel adds the firmware version from cmswinfo.html as label to
+	// all TC4400 metrics.
+	FirmwareLabel bool
+
+	// PollInterval makes Collect serve the result of the last Poll instead
+	// of scraping TC4400 if > 0.
+	PollInterval time.Duration
+
+	// UpstreamMaxLevel is the maximum upstream transmit level in dBmV the
+	// headroom is computed against if TC4400 doesn't report it, disabled if
+	// 0.
+	UpstreamMaxLevel float64
+
+	// DecimalSeparator is the decimal separator of numbers with a fraction
+	// like levels and SNR, "." if empty.
+	DecimalSeparator string
+
+	// ExcludeUnlocked drops the level, SNR and modulation metrics of
+	// channels that aren't locked.
+	ExcludeUnlocked bool
+
+	// ReceiveLevelBuckets are the buckets of the downstream receive level
+	// histogram, defaultReceiveLevelBuckets if empty.
+	ReceiveLevelBuckets []float64
+
+	// RoundValues rounds levels, SNR and similar values to RoundDecimals
+	// decimals to avoid series churn from insignificant changes.
+	RoundValues   bool
+	RoundDecimals int
+
+	// LegacyCounterTypes exports the channel frequencies, levels, SNR and
+	// status values of the built-in collector as counters like releases
+	// before they became gauges, for dashboards relying on that.
+	LegacyCounterTypes bool
+
+	// SkipNestedTables ignores tables nested in a cell of another table, so
+	// they don't shift the indices of the tables following them.
+	SkipNestedTables bool
+
+	// Transposed treats all channel tables as listing the channels as
+	// columns. Such tables are detected by their header otherwise.
+	Transposed bool
+
+	// LogoutAfterScrape requests the logout page after each scrape to
+	// release the only web session TC4400 allows.
+	LogoutAfterScrape bool
+
+	// ThroughputEstimate exports the theoretical downstream throughput
+	// estimated from the widths and modulations of the bonded channels.
+	ThroughputEstimate bool
+
+	// Subsystems are the subsystem names of the downstream, upstream and
+	// network metrics of the built-in collector.
+	Subsystems Subsystems
+
+	// ScrapeSamples exports the number of samples of the last scrape.
+	ScrapeSamples bool
+}
+
+// Subsystems are the subsystem names of metric groups, the part of the
+// metric names between namespace and metric. Empty names are replaced by the
+// defaults "downstream", "upstream" and "network".
+type Subsystems struct {
+	Downstream string
+	Upstream   string
+	Network    string
+}
+
+func (s Subsystems) withDefaults() Subsystems {
+	if s.Downstream == "" {
+		s.Downstream = "downstream"
+	}
+	if s.Upstream == "" {
+		s.Upstream = "upstream"
+	}
+	if s.Network == "" {
+		s.Network = "network"
+	}
+	return s
+}
+
+// subsystemName matches valid subsystem names.
+var subsystemName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// fixedSubsystems are the subsystem names of the metrics that can't be
+// renamed.
+var fixedSubsystems = map[string]bool{
+	"cm":           true,
+	"device":       true,
+	"exporter":     true,
+	"firmware":     true,
+	"provisioned":  true,
+	"provisioning": true,
+	"signal":       true,
+	"system":       true,
+}
+
+// Validate checks that the subsystem names are valid in metric names and
+// distinct from each other and from the fixed subsystems, so the metrics of
+// different groups can't collide.
+func (s Subsystems) Validate() error {
+	s = s.withDefaults()
+	seen := map[string]bool{}
+	for _, name := range []string{s.Downstream, s.Upstream, s.Network} {
+		if !subsystemName.MatchString(name) {
+			return fmt.Errorf("Invalid subsystem name %q", name)
+		}
+		if fixedSubsystems[name] {
+			return fmt.Errorf("Subsystem name %q is used by other metrics", name)
+		}
+		if seen[name] {
+			return fmt.Errorf("Subsystem name %q used twice", name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// defaultReceiveLevelBuckets span the DOCSIS downstream receive level range
+// in dBmV.
+var defaultReceiveLevelBuckets = []float64{-15, -10, -5, 0, 5, 10, 15}
+
+// ParseBuckets parses a comma separated list of increasing histogram bucket
+// upper bounds.
+func ParseBuckets(list string) ([]float64, error) {
+	buckets := []float64{}
+	for _, field := range strings.Split(list, ",") {
+		bucket, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid bucket %q: %v", field, err)
+		}
+		if len(buckets) > 0 && bucket <= buckets[len(buckets)-1] {
+			return nil, fmt.Errorf("Buckets must be increasing: %s", list)
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets, nil
+}
+
+// queueWaitBuckets returns the request duration buckets preceded by buckets
+// for short waits.
+func queueWaitBuckets(durationBuckets []float64) []float64 {
+	buckets := []float64{}
+	for _, b := range []float64{.001, .01, .1} {
+		if b < durationBuckets[0] {
+			buckets = append(buckets, b)
+		}
+	}
+	return append(buckets, durationBuckets...)
+}
+
+// defaultDurationBuckets returns histogram buckets for TC4400 request
+// latencies, which mostly take several seconds. They have one second
+// resolution up to 10s and get coarser up to the client timeout.
+func defaultDurationBuckets(timeout time.Duration) []float64 {
+	buckets := []float64{0.25, 0.5, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	for _, b := range []float64{15, 20, 30, 45, 60, 90, 120} {
+		if b >= timeout.Seconds() {
+			break
+		}
+		buckets = append(buckets, b)
+	}
+	if timeout.Seconds() > buckets[len(buckets)-1] {
+		buckets = append(buckets, timeout.Seconds())
+	}
+	return buckets
+}
+
+// ParseInterfaceRenames parses "from=to" interface name mappings.
+func ParseInterfaceRenames(mappings []string) (map[string]string, error) {
+	renames := map[string]string{}
+	for _, m := range mappings {
+		parts := strings.SplitN(m, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("Invalid interface rename %q, expected from=to", m)
+		}
+		renames[parts[0]] = parts[1]
+	}
+	return renames, nil
+}
 
 type Exporter struct {
 	baseURL string
 	client  *http.Client
+	timeout time.Duration
+	options Options
+	rand    *rand.Rand
+	descs   *descriptors
 	mutex   sync.RWMutex
 
+	configPages      []configPage
+	downstreamStates map[string]*downstreamState
+	requests         int
+	firmware         string
+	ctx              context.Context
+	password         string
+
+	// resets is the last reported reboot count, -1 until one was reported.
+	resets int64
+
+	// quality accumulates the channel values of the signal quality score
+	// during a scrape.
+	quality signalQuality
+
+	// passwordChange is set by fetch if TC4400 served a page requiring a
+	// password change during the current scrape.
+	passwordChange bool
+
+	// authRetried is set by fetch once it repeated a request after a 401
+	// during the current scrape, so each scrape repeats at most one.
+	authRetried bool
+	// rejectedCredentials are the credentials a repeated request was
+	// rejected with, if credentialsRejected is set. Requests with them
+	// aren't repeated until one succeeds or they change, e.g. by a password
+	// reload.
+	rejectedCredentials string
+	credentialsRejected bool
+
+	// scrapeParseErrors counts the parse errors of the current scrape,
+	// scrapeFailedPages lists the pages that couldn't be fetched.
+	scrapeParseErrors int
+	scrapeFailedPages []string
+
+	// cache holds the metrics of the last poll. It is guarded by
+	// cacheMutex instead of mutex, which a poll holds while scraping.
+	cache      []prometheus.Metric
+	cacheMutex sync.RWMutex
+	lastPoll   prometheus.Gauge
+
 	totalScrapes          prometheus.Counter
+	metricsEmitted        prometheus.Gauge
+	samplesScraped        prometheus.Gauge
+	samplesPostRelabeling prometheus.Gauge
+	requestsPerScrape     prometheus.Gauge
+	fixtureCheck          prometheus.Gauge
 	parseFailures         *prometheus.CounterVec
+	lastScrapeParseErrors prometheus.Gauge
+	truncatedResponses    *prometheus.CounterVec
+	responseBytes         *prometheus.GaugeVec
+	authChallenges        *prometheus.CounterVec
+	connectionInfo        *prometheus.GaugeVec
+	connectionsReused     prometheus.Counter
+	connectionsNew        prometheus.Counter
+	lastErrorInfo         *prometheus.GaugeVec
+	skippedRows           *prometheus.CounterVec
+	passwordChangeMetric  prometheus.Gauge
+	cappedChannels        *prometheus.CounterVec
+	cappedInterfaces      prometheus.Counter
 	clientRequestCount    *prometheus.CounterVec
 	clientRequestDuration *prometheus.HistogramVec
+	parseDuration         *prometheus.HistogramVec
+	queueWait             prometheus.Histogram
 }
 
-func NewExporter(uri string, timeout time.Duration) (*Exporter, error) {
+func NewExporter(uri string, timeout time.Duration, options Options) (*Exporter, error) {
+	// Without a namespace up would collide with the up series Prometheus
+	// adds to every target.
+	if options.Namespace == "" {
+		return nil, errors.New("The metric namespace must not be empty")
+	}
+	namespace := options.Namespace
 	client := &http.Client{}
 	client.Timeout = timeout
 
+	var selfLabels prometheus.Labels
+	if options.SelfTargetLabel {
+		selfLabels = prometheus.Labels{"target": scrapeTarget(uri)}
+	}
+
 	clientRequestCount := prometheus.NewCounterVec(prometheus.CounterOpts{
-		Namespace: namespace,
-		Name:      "exporter_client_requests_total",
-		Help:      "HTTP requests to TC4400",
+		Namespace:   namespace,
+		Name:        "exporter_client_requests_total",
+		Help:        "HTTP requests to TC4400",
+		ConstLabels: selfLabels,
 	}, []string{"code", "method"})
 
+	buckets := options.DurationBuckets
+	if len(buckets) == 0 {
+		buckets = defaultDurationBuckets(timeout)
+	}
 	clientRequestDuration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
-		Namespace: namespace,
-		Name:      "exporter_client_request_duration_seconds",
-		Help:      "Histogram of TC4400 HTTP request latencies.",
+		Namespace:   namespace,
+		Name:        "exporter_client_request_duration_seconds",
+		Help:        "Histogram of TC4400 HTTP request latencies.",
+		ConstLabels: selfLabels,
+		Buckets:     buckets,
 	}, []string{"code", "method"})
 
 	client.Transport = promhttp.InstrumentRoundTripperCounter(clientRequestCount,
 		promhttp.InstrumentRoundTripperDuration(clientRequestDuration, http.DefaultTransport))
 
-	return &Exporter{
-		baseURL: uri,
-		client:  client,
+	fixtureCheck := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Name:        "exporter_fixture_check",
+		Help:        "Whether an embedded reference page parsed as expected at startup.",
+		ConstLabels: selfLabels,
+	})
+	if checkFixture() {
+		fixtureCheck.Set(1)
+	} else {
+		log.Errorln("Parsing the embedded reference page yielded unexpected metrics")
+	}
+
+	var password string
+	if options.PasswordFile != "" {
+		var err error
+		password, err = readPassword(options.PasswordFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	e := &Exporter{
+		baseURL:          uri,
+		client:           client,
+		timeout:          timeout,
+		options:          options,
+		rand:             rand.New(rand.NewSource(time.Now().UnixNano())),
+		downstreamStates: map[string]*downstreamState{},
+		ctx:              context.Background(),
+		password:         password,
+		resets:           -1,
 		totalScrapes: prometheus.NewCounter(prometheus.CounterOpts{
-			Namespace: namespace,
-			Name:      "exporter_scrapes_total",
-			Help:      "Current total TC4400 scrapes.",
+			Namespace:   namespace,
+			Name:        "exporter_scrapes_total",
+			Help:        "Current total TC4400 scrapes.",
+			ConstLabels: selfLabels,
+		}),
+		metricsEmitted: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "exporter_metrics_emitted",
+			Help:        "Number of TC4400 metrics emitted by the last scrape.",
+			ConstLabels: selfLabels,
+		}),
+		samplesScraped: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "exporter_scrape_samples_scraped",
+			Help:        "Number of TC4400 samples, counting each histogram bucket, emitted by the last scrape.",
+			ConstLabels: selfLabels,
+		}),
+		samplesPostRelabeling: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "exporter_scrape_samples_post_metric_relabeling",
+			Help:        "Number of TC4400 samples emitted by the last scrape after filtering, equal to exporter_scrape_samples_scraped as none is applied.",
+			ConstLabels: selfLabels,
+		}),
+		fixtureCheck: fixtureCheck,
+		requestsPerScrape: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "exporter_requests_per_scrape",
+			Help:        "Number of HTTP requests to TC4400 issued by the last scrape.",
+			ConstLabels: selfLabels,
 		}),
 		parseFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
-			Namespace: namespace,
-			Name:      "exporter_parse_errors_total",
-			Help:      "Number of errors while parsing HTML tables.",
+			Namespace:   namespace,
+			Name:        "exporter_parse_errors_total",
+			Help:        "Number of errors while parsing HTML tables.",
+			ConstLabels: selfLabels,
+		}, []string{"file", "reason"}),
+		lastScrapeParseErrors: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "exporter_last_scrape_parse_errors",
+			Help:        "Number of errors while parsing HTML tables during the last scrape.",
+			ConstLabels: selfLabels,
+		}),
+		cappedChannels: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "exporter_channel_cap_hits_total",
+			Help:        "Number of scrapes in which channels beyond the maximum channel count were dropped.",
+			ConstLabels: selfLabels,
+		}, []string{"direction"}),
+		cappedInterfaces: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "exporter_interface_cap_hits_total",
+			Help:        "Number of scrapes in which interfaces beyond the maximum interface count were dropped.",
+			ConstLabels: selfLabels,
+		}),
+		truncatedResponses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "exporter_truncated_responses_total",
+			Help:        "Number of TC4400 responses shorter than their Content-Length.",
+			ConstLabels: selfLabels,
+		}, []string{"file"}),
+		responseBytes: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "exporter_response_bytes",
+			Help:        "Size in bytes of the last response body of each TC4400 page.",
+			ConstLabels: selfLabels,
+		}, []string{"file"}),
+		authChallenges: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "exporter_auth_challenges_total",
+			Help:        "Number of TC4400 responses requiring authentication.",
+			ConstLabels: selfLabels,
 		}, []string{"file"}),
+		connectionInfo: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "exporter_connection_info",
+			Help:        "HTTP protocol and TLS version of the last response from TC4400, tls is empty for plain HTTP.",
+			ConstLabels: selfLabels,
+		}, []string{"proto", "tls"}),
+		connectionsReused: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "exporter_connection_reused_total",
+			Help:        "Number of requests to TC4400 reusing a kept-alive connection.",
+			ConstLabels: selfLabels,
+		}),
+		connectionsNew: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "exporter_connection_new_total",
+			Help:        "Number of requests to TC4400 opening a new connection.",
+			ConstLabels: selfLabels,
+		}),
+		passwordChangeMetric: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "exporter_password_change_required",
+			Help:        "Whether TC4400 served a page requiring a password change instead of the status pages in the last scrape.",
+			ConstLabels: selfLabels,
+		}),
+		skippedRows: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "exporter_skipped_rows_total",
+			Help:        "Number of table rows skipped because their cell count didn't match the table.",
+			ConstLabels: selfLabels,
+		}, []string{"file", "table"}),
+		lastErrorInfo: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "exporter_last_error_info",
+			Help:        "Category of the last error of the last scrape and the file it occurred in, absent if there was none.",
+			ConstLabels: selfLabels,
+		}, []string{"error", "file"}),
+		lastPoll: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "exporter_last_poll_timestamp_seconds",
+			Help:        "Time of the last poll of TC4400 since unix epoch in seconds.",
+			ConstLabels: selfLabels,
+		}),
 		clientRequestCount:    clientRequestCount,
 		clientRequestDuration: clientRequestDuration,
-	}, nil
+		parseDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   namespace,
+			Name:        "exporter_parse_duration_seconds",
+			Help:        "Histogram of TC4400 page parsing latencies.",
+			ConstLabels: selfLabels,
+			Buckets:     []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+		}, []string{"file"}),
+		queueWait: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace:   namespace,
+			Name:        "exporter_scrape_queue_wait_seconds",
+			Help:        "Histogram of the time scrapes waited for a running scrape of TC4400 to finish.",
+			ConstLabels: selfLabels,
+			Buckets:     queueWaitBuckets(buckets),
+		}),
+	}
+	e.descs = newDescriptors(namespace, options.Subsystems, e.constLabels())
+	if options.Config != nil {
+		e.configPages = newConfigPages(options.Config, namespace, e.constLabels())
+	}
+	return e, nil
 }
 
 func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
-	for _, m := range networkMetrics {
-		ch <- m
-	}
-	for _, m := range downstreamChannelMetrics {
-		ch <- m
+	// The firmware label changes the descriptors at runtime, so the exporter
+	// is registered as unchecked collector by describing nothing.
+	if e.options.FirmwareLabel {
+		return
 	}
-	for _, m := range upstreamChannelMetrics {
-		ch <- m
+
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	for _, page := range e.configPages {
+		for _, table := range page.tables {
+			for _, m := range table.metrics {
+				ch <- m.desc
+			}
+		}
 	}
+	e.describeBuiltin(ch)
 
-	ch <- targetUpMetric
+	ch <- e.descs.targetUp
 	ch <- e.totalScrapes.Desc()
+	ch <- e.metricsEmitted.Desc()
+	if e.options.ScrapeSamples {
+		ch <- e.samplesScraped.Desc()
+		ch <- e.samplesPostRelabeling.Desc()
+	}
+	ch <- e.requestsPerScrape.Desc()
+	ch <- e.fixtureCheck.Desc()
+	ch <- e.passwordChangeMetric.Desc()
+	ch <- e.lastScrapeParseErrors.Desc()
 	e.parseFailures.Describe(ch)
+	e.truncatedResponses.Describe(ch)
+	e.responseBytes.Describe(ch)
+	e.authChallenges.Describe(ch)
+	e.connectionInfo.Describe(ch)
+	e.connectionsReused.Describe(ch)
+	e.connectionsNew.Describe(ch)
+	e.lastErrorInfo.Describe(ch)
+	e.skippedRows.Describe(ch)
+	e.cappedChannels.Describe(ch)
+	e.cappedInterfaces.Describe(ch)
+	if e.options.PollInterval > 0 {
+		ch <- e.lastPoll.Desc()
+	}
 	e.clientRequestCount.Describe(ch)
 	e.clientRequestDuration.Describe(ch)
+	e.parseDuration.Describe(ch)
+	e.queueWait.Describe(ch)
+}
+
+func (e *Exporter) describeBuiltin(ch chan<- *prometheus.Desc) {
+	if e.configPages != nil {
+		return
+	}
+	for _, m := range e.descs.network {
+		ch <- m
+	}
+	for _, m := range e.descs.downstream {
+		ch <- m
+	}
+	for _, m := range e.descs.upstream {
+		ch <- m
+	}
+	if e.options.ChannelHealth {
+		ch <- e.descs.downstreamChannelHealth
+	}
+	if e.options.QualityScore {
+		ch <- e.descs.signalQualityScore
+	}
+	if e.options.ThroughputEstimate {
+		ch <- e.descs.downstreamThroughput
+	}
+	ch <- e.descs.downstreamChannelTypeInfo
+	ch <- e.descs.downstreamPLCLocked
+	ch <- e.descs.downstreamSNRMargin
+	ch <- e.descs.downstreamChannelOverlap
+	ch <- e.descs.downstreamDistinctModulations
+	ch <- e.descs.downstreamReceiveLevels
+	ch <- e.descs.parserVariant
+	ch <- e.descs.downstreamBondedActive
+	ch <- e.descs.downstreamChannelsByType
+	ch <- e.descs.downstreamSNRSpread
+	ch <- e.descs.downstreamLockedRatio
+	ch <- e.descs.upstreamLockedRatio
+	ch <- e.descs.downstreamBondingGroupSize
+	ch <- e.descs.downstreamUncorrectableEvents
+	ch <- e.descs.downstreamLockFlaps
+	ch <- e.descs.downstreamFrequencyChanges
+	ch <- e.descs.downstreamProfileDowngrades
+	ch <- e.descs.upstreamTransmitHeadroom
+	ch <- e.descs.downstreamCounterReset
+	ch <- e.descs.downstreamFECFailureRatio
+	ch <- e.descs.provisioningStep
+	ch <- e.descs.cmIPInfo
+	ch <- e.descs.cmTFTPServerInfo
+	ch <- e.descs.cmCPECount
+	ch <- e.descs.deviceTemperature
+	ch <- e.descs.firmwareUpdatePending
+	ch <- e.descs.systemResets
+	ch <- e.descs.deviceInfo
+	ch <- e.descs.provisionedMaxDownstream
+	ch <- e.descs.provisionedMaxUpstream
+	ch <- e.descs.cmDHCPLease
+	ch <- e.descs.cmDHCPLeaseRemaining
+	if e.options.StringInfo {
+		ch <- e.descs.downstreamStringInfo
+		ch <- e.descs.upstreamStringInfo
+	}
 }
 
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
-	e.mutex.Lock()
-	defer e.mutex.Unlock()
+	// The cache holds the self-metrics as well, so scrapes don't wait for
+	// a running poll.
+	if e.options.PollInterval > 0 {
+		e.collectCache(ch)
+		return
+	}
+
+	e.lockModem()
+	e.collectModem(ch)
+	e.mutex.Unlock()
 
-	up := e.scrape(ch)
-	ch <- prometheus.MustNewConstMetric(targetUpMetric, prometheus.GaugeValue, up)
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	e.collectSelf(ch)
+}
 
+// collectSelf sends the metrics about the exporter itself. It must be called
+// with e.mutex held.
+func (e *Exporter) collectSelf(ch chan<- prometheus.Metric) {
 	ch <- e.totalScrapes
+	ch <- e.metricsEmitted
+	if e.options.ScrapeSamples {
+		ch <- e.samplesScraped
+		ch <- e.samplesPostRelabeling
+	}
+	ch <- e.requestsPerScrape
+	ch <- e.fixtureCheck
+	ch <- e.passwordChangeMetric
+	ch <- e.lastScrapeParseErrors
 	e.parseFailures.Collect(ch)
+	e.truncatedResponses.Collect(ch)
+	e.responseBytes.Collect(ch)
+	e.authChallenges.Collect(ch)
+	e.connectionInfo.Collect(ch)
+	e.connectionsReused.Collect(ch)
+	e.connectionsNew.Collect(ch)
+	e.lastErrorInfo.Collect(ch)
+	e.skippedRows.Collect(ch)
+	e.cappedChannels.Collect(ch)
+	e.cappedInterfaces.Collect(ch)
 	e.clientRequestCount.Collect(ch)
 	e.clientRequestDuration.Collect(ch)
+	e.parseDuration.Collect(ch)
+	e.queueWait.Collect(ch)
 }
 
-func (e *Exporter) fetch(filename string) (io.ReadCloser, error) {
-	u, err := url.Parse(e.baseURL)
-	if err != nil {
-		return nil, err
+// lockModem acquires the mutex for a scrape, observing how long it waited
+// for another scrape to finish.
+func (e *Exporter) lockModem() {
+	start := time.Now()
+	e.mutex.Lock()
+	e.queueWait.Observe(time.Since(start).Seconds())
+}
+
+// collectModem scrapes TC4400 and sends the resulting metrics. It must be
+// called with e.mutex held.
+func (e *Exporter) collectModem(ch chan<- prometheus.Metric) {
+	// Forward the modem metrics through a separate channel to count them.
+	scrapeCh := make(chan prometheus.Metric)
+	emitted := make(chan int)
+	samples := 0
+	go func() {
+		n := 0
+		for m := range scrapeCh {
+			if e.options.ScrapeSamples {
+				samples += metricSamples(m)
+			}
+			ch <- m
+			n++
+		}
+		emitted <- n
+	}()
+	e.requests = 0
+	up := e.scrape(scrapeCh)
+	close(scrapeCh)
+	e.metricsEmitted.Set(float64(<-emitted))
+	e.requestsPerScrape.Set(float64(e.requests))
+
+	upMetric := prometheus.MustNewConstMetric(e.descs.targetUp, prometheus.GaugeValue, up)
+	if e.options.ScrapeSamples {
+		samples += metricSamples(upMetric)
+		e.samplesScraped.Set(float64(samples))
+		e.samplesPostRelabeling.Set(float64(samples))
 	}
-	u.Path = path.Join(u.Path, filename)
+	ch <- upMetric
+}
+
+// metricSamples returns the number of samples of a metric in the text
+// exposition format: one, or the buckets or quantiles plus sum and count.
+func metricSamples(m prometheus.Metric) int {
+	pb := &dto.Metric{}
+	if err := m.Write(pb); err != nil {
+		return 1
+	}
+	switch {
+	case pb.Histogram != nil:
+		// The +Inf bucket is implicit.
+		return len(pb.Histogram.Bucket) + 3
+	case pb.Summary != nil:
+		return len(pb.Summary.Quantile) + 2
+	}
+	return 1
+}
+
+func (e *Exporter) fetch(filename string) (body io.ReadCloser, err error) {
+	defer func() {
+		if err != nil {
+			e.scrapeFailedPages = append(e.scrapeFailedPages, filename)
+			e.setLastError(errorCategory(err), filename)
+		}
+	}()
 
-	resp, err := e.client.Get(u.String())
+	u, err := pageURL(e.baseURL, filename)
 	if err != nil {
 		return nil, err
 	}
+
+	ctx, cancel := context.WithTimeout(e.ctx, e.requestTimeout())
+	defer cancel()
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				e.connectionsReused.Inc()
+			} else {
+				e.connectionsNew.Inc()
+			}
+		},
+	})
+
+	// A 401 is counted as auth challenge and the request is repeated, as
+	// TC4400 may reject the first request of a new session. That is done
+	// once per scrape at most, and not for credentials a repeated request
+	// was rejected with until a request with them succeeds.
+	credentials := e.credentials(u)
+	var resp *http.Response
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		e.setAuth(req, u)
+
+		e.requests++
+		resp, err = e.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusUnauthorized {
+			e.credentialsRejected = false
+			break
+		}
+		e.authChallenges.WithLabelValues(filename).Inc()
+		if attempt > 0 {
+			e.rejectedCredentials, e.credentialsRejected = credentials, true
+			break
+		}
+		if e.authRetried || (e.credentialsRejected && e.rejectedCredentials == credentials) {
+			break
+		}
+		e.authRetried = true
+		resp.Body.Close()
+	}
+	e.connectionInfo.Reset()
+	e.connectionInfo.WithLabelValues(resp.Proto, tlsVersion(resp.TLS)).Set(1)
 	if !(resp.StatusCode >= 200 && resp.StatusCode < 300) {
 		resp.Body.Close()
-		return nil, fmt.Errorf("Scraping %s failed: HTTP status %d", u.String(), resp.StatusCode)
+		return nil, &httpStatusError{u.String(), resp.StatusCode}
 	}
-	return resp.Body, nil
+
+	// Read the whole page so truncated responses can be detected before
+	// parsing instead of silently yielding incomplete tables.
+	content, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	e.responseBytes.WithLabelValues(filename).Set(float64(len(content)))
+	if err == io.ErrUnexpectedEOF || (err == nil && resp.ContentLength >= 0 && int64(len(content)) != resp.ContentLength) {
+		e.truncatedResponses.WithLabelValues(filename).Inc()
+		return nil, fmt.Errorf("Scraping %s failed: read %d of %d bytes", u.String(), len(content), resp.ContentLength)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if requiresPasswordChange(content) {
+		e.passwordChange = true
+		return nil, errPasswordChange
+	}
+
+	// Transcode to UTF-8 based on the Content-Type header or <meta> charset.
+	r, err := charset.NewReader(bytes.NewReader(content), resp.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(r), nil
+}
+
+// errPasswordChange is returned by fetch for pages requiring a password
+// change.
+var errPasswordChange = errors.New("TC4400 requires a password change")
+
+// requiresPasswordChange reports whether a page is the form forcing a
+// password change instead of the requested page: a page titled like "Change
+// Password" or with a password input for the new password. Advice to change
+// the password elsewhere on a page, like a banner above the status tables,
+// doesn't count.
+func requiresPasswordChange(content []byte) bool {
+	z := html.NewTokenizer(bytes.NewReader(content))
+	inTitle := false
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return false
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			switch string(name) {
+			case "title":
+				inTitle = true
+			case "input":
+				var inputType, inputName string
+				for hasAttr {
+					var key, val []byte
+					key, val, hasAttr = z.TagAttr()
+					switch string(key) {
+					case "type":
+						inputType = strings.ToLower(string(val))
+					case "name", "id":
+						inputName += strings.ToLower(string(val))
+					}
+				}
+				if inputType == "password" && strings.Contains(inputName, "new") {
+					return true
+				}
+			}
+		case html.EndTagToken:
+			if name, _ := z.TagName(); string(name) == "title" {
+				inTitle = false
+			}
+		case html.TextToken:
+			if inTitle {
+				title := strings.ToLower(string(z.Text()))
+				if strings.Contains(title, "password") && strings.Contains(title, "change") {
+					return true
+				}
+			}
+		}
+	}
+}
+
+// pageURL returns the URL of a page below the base URI, which may have a
+// path with or without trailing slash like "https://proxy/modem1/".
+func pageURL(baseURL, filename string) (*url.URL, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.RawPath != "" {
+		// Keep escaped slashes in the base path.
+		u.RawPath = path.Join("/", u.RawPath, url.PathEscape(filename))
+	}
+	u.Path = path.Join("/", u.Path, filename)
+	return u, nil
+}
+
+// tlsVersion returns the TLS version of a connection like "1.2", or an empty
+// string for plain HTTP.
+func tlsVersion(state *tls.ConnectionState) string {
+	if state == nil {
+		return ""
+	}
+	switch state.Version {
+	case tls.VersionTLS10:
+		return "1.0"
+	case tls.VersionTLS11:
+		return "1.1"
+	case tls.VersionTLS12:
+		return "1.2"
+	case tls.VersionTLS13:
+		return "1.3"
+	}
+	return "unknown"
+}
+
+// parseTables parses the tables of a fetched page and observes how long that
+// took, whether it succeeded or not.
+func (e *Exporter) parseTables(filename string, body io.ReadCloser) ([][][]string, []string, error) {
+	start := time.Now()
+	defer func() {
+		e.parseDuration.WithLabelValues(filename).Observe(time.Since(start).Seconds())
+	}()
+	return parseHeadedTables(body, e.options.SkipNestedTables)
+}
+
+// parseFailed logs a parse error and counts it by file and reason.
+func (e *Exporter) parseFailed(err error) {
+	log.Errorln(err)
+	file, reason := "", "unknown"
+	var parseErr *ParseError
+	if errors.As(err, &parseErr) {
+		file, reason = parseErr.File, parseErr.Reason
+	}
+	e.parseFailures.WithLabelValues(file, reason).Inc()
+	e.scrapeParseErrors++
+	e.setLastError("parse", file)
+}
+
+// rowSkipped counts a row skipped for its cell count.
+func (e *Exporter) rowSkipped(file string, table int) {
+	e.skippedRows.WithLabelValues(file, strconv.Itoa(table)).Inc()
+}
+
+// setLastError replaces the last error of the current scrape.
+func (e *Exporter) setLastError(category, file string) {
+	e.lastErrorInfo.Reset()
+	e.lastErrorInfo.WithLabelValues(category, file).Set(1)
+}
+
+// errorCategory maps a fetch error to one of a small fixed set of
+// categories.
+func errorCategory(err error) string {
+	var statusErr *httpStatusError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &statusErr) && statusErr.code >= 400 && statusErr.code < 500:
+		return "http_4xx"
+	case errors.As(err, &statusErr) && statusErr.code >= 500:
+		return "http_5xx"
+	case errors.As(err, &statusErr):
+		return "http"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection_refused"
+	case errors.Is(err, errPasswordChange):
+		return "password_change"
+	}
+	return "other"
+}
+
+// httpStatusError is returned by fetch for non-2xx responses.
+type httpStatusError struct {
+	url  string
+	code int
+}
+
+func (err *httpStatusError) Error() string {
+	return fmt.Sprintf("Scraping %s failed: HTTP status %d", err.url, err.code)
+}
+
+// Probe fetches a single page to check that the modem is reachable and
+// accepts the configured credentials.
+func (e *Exporter) Probe() error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	body, err := e.fetch("cmconnectionstatus.html")
+	if err != nil {
+		if statusErr, ok := err.(*httpStatusError); ok && (statusErr.code == http.StatusUnauthorized || statusErr.code == http.StatusForbidden) {
+			return fmt.Errorf("TC4400 rejected the credentials in --client.scrape-uri: HTTP status %d", statusErr.code)
+		}
+		return err
+	}
+	body.Close()
+	return nil
+}
+
+// requestTimeout returns the client timeout shortened by a random jitter of
+// up to TimeoutJitter, which is capped at half the timeout.
+func (e *Exporter) requestTimeout() time.Duration {
+	jitter := e.options.TimeoutJitter
+	if jitter > e.timeout/2 {
+		jitter = e.timeout / 2
+	}
+	if jitter <= 0 {
+		return e.timeout
+	}
+	return e.timeout - time.Duration(e.rand.Int63n(int64(jitter)))
 }
 
 func (e *Exporter) scrape(ch chan<- prometheus.Metric) (up float64) {
 	e.totalScrapes.Inc()
+	e.lastErrorInfo.Reset()
+	e.scrapeParseErrors = 0
+	e.scrapeFailedPages = nil
+	e.authRetried = false
+	defer func() {
+		e.lastScrapeParseErrors.Set(float64(e.scrapeParseErrors))
+	}()
+	if e.options.LogoutAfterScrape {
+		defer e.logout()
+	}
 
-	// networkMetrics - statsifc.html
+	e.passwordChange = false
+	defer func() {
+		if e.passwordChange {
+			log.Errorln("TC4400 requires a password change, no metrics can be scraped")
+			up = 0
+			e.passwordChangeMetric.Set(1)
+		} else {
+			e.passwordChangeMetric.Set(0)
+		}
+	}()
+
+	if e.options.CollectorTimeout > 0 {
+		// The context of a probe request is kept as parent.
+		parent := e.ctx
+		var cancel context.CancelFunc
+		e.ctx, cancel = context.WithTimeout(parent, e.options.CollectorTimeout)
+		defer func() {
+			if e.ctx.Err() != nil && parent.Err() == nil {
+				log.Errorln("Scrape exceeded the collector timeout of", e.options.CollectorTimeout)
+				up = 0
+			}
+			cancel()
+			e.ctx = parent
+		}()
+	}
+
+	// The firmware version has to be known before emitting any metric.
+	var status map[string]string
+	if e.options.FirmwareLabel {
+		status = e.fetchStatus()
+		if version, ok := lookupKV(status, firmwareKeys...); ok {
+			e.setFirmware(version)
+		}
+	}
+
+	if e.configPages != nil {
+		e.scrapeConfig(ch)
+		return 1
+	}
 
+	if e.options.CollectStatsifc {
+		e.scrapeNetwork(ch)
+	}
+	up = 1
+	if e.options.CollectCMConnectionStatus {
+		downstreamChannels, upstreamChannels := e.scrapeConnectionStatus(ch)
+		if downstreamChannels < e.options.MinDownstreamChannels || upstreamChannels < e.options.MinUpstreamChannels {
+			log.Warnf("Channel count below threshold: %d downstream, %d upstream", downstreamChannels, upstreamChannels)
+			up = 0
+		}
+	}
+	if e.options.CollectCMSWInfo || e.options.DeviceInfo {
+		if status == nil {
+			status = e.fetchStatus()
+		}
+		e.emitStatus(ch, status)
+	}
+
+	return up
+}
+
+// scrapeNetwork emits the network interface metrics - statsifc.html
+func (e *Exporter) scrapeNetwork(ch chan<- prometheus.Metric) {
 	body, err := e.fetch("statsifc.html")
 	if err == nil {
-		tables, err := parseTables(body)
+		tables, _, err := e.parseTables("statsifc.html", body)
 		body.Close()
 		if err != nil {
-			log.Errorln(err)
-			e.parseFailures.WithLabelValues("statsifc.html").Inc()
+			e.parseFailed(&ParseError{"statsifc.html", -1, -1, -1, parseReasonHTML, err})
 		} else {
 			if len(tables) < 1 || len(tables[0]) < 2 {
-				log.Errorln("No table found in statsifc.html")
-				e.parseFailures.WithLabelValues("statsifc.html").Inc()
+				e.parseFailed(&ParseError{"statsifc.html", 0, -1, -1, parseReasonTable, errors.New("No table found")})
 			} else {
-				for _, row := range tables[0][2:] {
+				interfaces := 0
+				for r, row := range tables[0][2:] {
 					if len(row) != 9 {
+						e.rowSkipped("statsifc.html", 0)
 						continue
 					}
+					if e.options.MaxInterfaces > 0 && interfaces >= e.options.MaxInterfaces {
+						log.Warnf("More than %d network interfaces, ignoring the rest", e.options.MaxInterfaces)
+						e.cappedInterfaces.Inc()
+						break
+					}
+					interfaces++
+
+					iface := row[0]
+					if name, ok := e.options.InterfaceRenames[iface]; ok {
+						iface = name
+					}
 
-					for i, metric := range networkMetrics {
-						valueInt, err := strconv.ParseInt(row[i], 10, 64)
+					for i, metric := range e.descs.network {
+						valueInt, err := parseNumericCell(row[i])
 						value := float64(valueInt)
+						if err == errAbsent {
+							continue
+						}
 						if err != nil {
-							log.Errorln(err)
-							e.parseFailures.WithLabelValues("statsifc.html").Inc()
+							e.parseFailed(&ParseError{"statsifc.html", 0, r + 2, i, parseReasonValue, err})
 							continue
 						}
-						ch <- prometheus.MustNewConstMetric(metric, prometheus.CounterValue, value, row[0])
+						ch <- prometheus.MustNewConstMetric(metric, prometheus.CounterValue, value, iface)
 					}
 				}
 			}
 		}
 	}
+}
+
+// scrapeConnectionStatus emits the upstream and downstream channel metrics
+// and returns the number of channels parsed - cmconnectionstatus.html
+func (e *Exporter) scrapeConnectionStatus(ch chan<- prometheus.Metric) (downstreamCount, upstreamCount int) {
+	body, err := e.fetch("cmconnectionstatus.html")
+	if err != nil {
+		return
+	}
+	tables, headings, err := e.parseTables("cmconnectionstatus.html", body)
+	body.Close()
+	if err != nil {
+		e.parseFailed(&ParseError{"cmconnectionstatus.html", -1, -1, -1, parseReasonHTML, err})
+		return
+	}
+	return e.emitConnectionStatus(ch, tables, headings)
+}
 
-	// upstreamChannelMetrics, downstreamChannelMetrics - cmconnectionstatus.html
+// emitConnectionStatus emits the metrics of the tables of
+// cmconnectionstatus.html and returns the number of channels parsed.
+func (e *Exporter) emitConnectionStatus(ch chan<- prometheus.Metric, tables [][][]string, headings []string) (downstreamCount, upstreamCount int) {
+	e.quality = signalQuality{}
 
-	body, err = e.fetch("cmconnectionstatus.html")
-	if err == nil {
-		tables, err := parseTables(body)
-		body.Close()
-		if err != nil {
-			log.Errorln(err)
-			e.parseFailures.WithLabelValues("cmconnectionstatus.html").Inc()
-		} else {
-			if len(tables) < 3 || len(tables[1]) < 2 || len(tables[2]) < 2 {
-				log.Errorln("Tables not found in cmconnectionstatus.html")
-				e.parseFailures.WithLabelValues("cmconnectionstatus.html").Inc()
-			} else {
+	// provisioning steps - only from a Startup Procedure table, another
+	// table would be misread as steps
+	startupIndex := selectTable(tables, headings, "Startup Procedure", -1, -1)
+	if startupIndex >= 0 && len(tables[startupIndex]) >= 2 {
+		for _, row := range tables[startupIndex][2:] {
+			if len(row) < 2 || row[0] == "" {
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(e.descs.provisioningStep, prometheus.GaugeValue, provisioningStepDone(row[1]), provisioningStepName(row[0]))
+		}
+	}
 
-				// downstreamChannelMetrics
-				for _, row := range tables[1][2:] {
-					if len(row) != 13 {
-						continue
-					}
+	tables = e.transposeTables(tables, headings)
+
+	// The channel tables are checked separately so a malformed table
+	// doesn't suppress the metrics of the other one.
+	// Firmware releases may split the downstream channels into several
+	// tables, e.g. SC-QAM and OFDM, whose channels are merged.
+	downstreamIndexes := selectDownstreamTables(tables, headings, e.options.DownstreamTableIndex)
+	if downstreamIndex := downstreamIndexes[0]; downstreamIndex < len(tables) && len(tables[downstreamIndex]) >= 2 {
+		downstreamCount = e.emitDownstream(ch, tables, downstreamIndexes)
+	} else {
+		e.parseFailed(&ParseError{"cmconnectionstatus.html", downstreamIndex, -1, -1, parseReasonTable, errors.New("Downstream table not found")})
+	}
+	upstreamIndex := selectTable(tables, headings, "Upstream", e.options.UpstreamTableIndex, 2)
+	if upstreamIndex < len(tables) && len(tables[upstreamIndex]) >= 2 {
+		upstreamCount = e.emitUpstream(ch, tables, upstreamIndex)
+	} else {
+		e.parseFailed(&ParseError{"cmconnectionstatus.html", upstreamIndex, -1, -1, parseReasonTable, errors.New("Upstream table not found")})
+	}
+
+	if e.options.QualityScore {
+		weights := e.options.QualityWeights
+		if weights == (QualityWeights{}) {
+			weights = defaultQualityWeights
+		}
+		if score, ok := e.quality.score(weights); ok {
+			ch <- prometheus.MustNewConstMetric(e.descs.signalQualityScore, prometheus.GaugeValue, score)
+		}
+	}
+	return
+}
 
-					channel, err := strconv.ParseInt(row[1], 10, 64)
-					if err != nil {
-						log.Errorln(err)
-						e.parseFailures.WithLabelValues("cmconnectionstatus.html").Inc()
+// transposeTables returns tables with the channel tables listing channels as
+// columns transposed to one row per channel, keeping their title row. With
+// Options.Transposed all downstream and upstream tables are transposed.
+func (e *Exporter) transposeTables(tables [][][]string, headings []string) [][][]string {
+	transposed := make([][][]string, len(tables))
+	for i, table := range tables {
+		transposed[i] = table
+		force := false
+		if e.options.Transposed && len(table) >= 2 && len(table[0]) > 0 {
+			title := strings.ToLower(table[0][0])
+			if i < len(headings) {
+				title = strings.ToLower(headings[i]) + " " + title
+			}
+			force = i == e.options.DownstreamTableIndex || i == e.options.UpstreamTableIndex ||
+				strings.Contains(title, "downstream") || strings.Contains(title, "upstream")
+		}
+		if force || isTransposed(table) {
+			transposed[i] = append([][]string{table[0]}, transpose(table[1:])...)
+		}
+	}
+	return transposed
+}
+
+// emitDownstream emits the downstream channel metrics of the tables at
+// downstreamIndexes, merging their channels, and returns the number of
+// channels parsed.
+func (e *Exporter) emitDownstream(ch chan<- prometheus.Metric, tables [][][]string, downstreamIndexes []int) (downstreamCount int) {
+	downstreamChannels := map[string]bool{}
+	downstreamBands := [][2]float64{}
+	downstreamModulations := map[string]bool{}
+	downstreamLevels := []float64{}
+	downstreamSNRs := []float64{}
+	ofdm := false
+	extended := false
+	bonded := 0
+	locked := 0
+	throughput := 0.0
+	channelTypes := map[string]int{}
+tables:
+	for _, downstreamIndex := range downstreamIndexes {
+		// Firmware releases reporting the OFDM PLC lock or the
+		// measured SNR do so in additional columns after the codeword
+		// counters.
+		downstreamColumns := 13
+		header := tables[downstreamIndex][1]
+		extraColumn := func(keyword string) int {
+			if len(header) <= downstreamColumns {
+				return -1
+			}
+			if i := selectColumn(header[downstreamColumns:], keyword); i >= 0 {
+				return downstreamColumns + i
+			}
+			return -1
+		}
+		plcColumn := extraColumn("PLC")
+		snrColumn := extraColumn("SNR")
+		if len(header) > downstreamColumns {
+			downstreamColumns = len(header)
+		}
+		extended = extended || plcColumn >= 0 || snrColumn >= 0
+		for r, row := range tables[downstreamIndex][2:] {
+			if len(row) != downstreamColumns {
+				e.rowSkipped("cmconnectionstatus.html", downstreamIndex)
+				continue
+			}
+			if e.options.MaxChannels > 0 && len(downstreamChannels) >= e.options.MaxChannels {
+				e.channelsCapped("downstream")
+				break tables
+			}
+
+			channel, err := strconv.ParseInt(row[1], 10, 64)
+			if err != nil {
+				e.parseFailed(&ParseError{"cmconnectionstatus.html", downstreamIndex, r + 2, 1, parseReasonValue, err})
+				continue
+			}
+			channelLabel := fmt.Sprintf("%02d", channel)
+			if downstreamChannels[channelLabel] {
+				e.parseFailed(&ParseError{"cmconnectionstatus.html", downstreamIndex, r + 2, 1, parseReasonValue, fmt.Errorf("Duplicate channel %s", channelLabel)})
+				continue
+			}
+
+			unlocked := e.options.ExcludeUnlocked && row[2] != "Locked"
+			for i, metric := range e.descs.downstream {
+				var err error = nil
+				var value float64
+				var valueInt int64
+				var labelValues = []string{channelLabel}
+				valueType := e.statusValueType()
+				if unlocked && (i == 7 || i == 8 || i == 9) {
+					continue
+				}
+				switch i {
+				case 10, 11, 12:
+					valueInt, err = parseNumericCell(row[i])
+					value = float64(valueInt)
+					valueType = prometheus.CounterValue
+				case 2:
+					if row[i] == "Locked" {
+						value = 1
+					} else {
+						value = 0
+					}
+				case 3, 9:
+					labelValues = append(labelValues, row[i])
+					value = 1
+				case 4:
+					if row[i] == "Bonded" {
+						value = 1
+					} else {
+						value = 0
+					}
+				case 5, 6:
+					number, unit := splitValueUnit(e.decimal(row[i]))
+					if number == "" || unit == "" {
 						continue
 					}
-					channelLabel := fmt.Sprintf("%02d", channel)
-
-					for i, metric := range downstreamChannelMetrics {
-						var err error = nil
-						var value float64
-						var valueInt int64
-						var labelValues = []string{channelLabel}
-						switch i {
-						case 10, 11, 12:
-							valueInt, err = strconv.ParseInt(row[i], 10, 64)
-							value = float64(valueInt)
-						case 2:
-							if row[i] == "Locked" {
-								value = 1
-							} else {
-								value = 0
-							}
-						case 3, 9:
-							labelValues = append(labelValues, row[i])
-							value = 1
-						case 4:
-							if row[i] == "Bonded" {
-								value = 1
-							} else {
-								value = 0
-							}
-						case 5, 6:
-							parts := strings.Split(row[i], " ")
-							if len(parts) != 2 {
-								continue
-							}
-							valueInt, err = strconv.ParseInt(parts[0], 10, 64)
-							switch parts[1] {
-							case "Hz":
-							case "kHz":
-								valueInt = valueInt * 1000
-							default:
-								continue
-							}
-							value = float64(valueInt)
-						case 7:
-							parts := strings.Split(row[i], " ")
-							if len(parts) != 2 || parts[1] != "dB" {
-								continue
-							}
-							value, err = strconv.ParseFloat(parts[0], 64)
-						case 8:
-							parts := strings.Split(row[i], " ")
-							if len(parts) != 2 || parts[1] != "dBmV" {
-								continue
-							}
-							value, err = strconv.ParseFloat(parts[0], 64)
-						default:
-							continue
-						}
-
-						if err != nil {
-							log.Errorln(err)
-							e.parseFailures.WithLabelValues("cmconnectionstatus.html").Inc()
-							continue
-						}
-						ch <- prometheus.MustNewConstMetric(metric, prometheus.CounterValue, value, labelValues...)
+					valueInt, err = strconv.ParseInt(number, 10, 64)
+					switch unit {
+					case "Hz":
+					case "kHz":
+						valueInt = valueInt * 1000
+					default:
+						continue
 					}
-				}
-
-				// upstreamChannelMetrics
-				for _, row := range tables[2][2:] {
-					if len(row) != 9 {
+					value = float64(valueInt)
+				case 7:
+					number, unit := splitValueUnit(e.decimal(row[i]))
+					if unit != "dB" {
 						continue
 					}
-
-					channel, err := strconv.ParseInt(row[1], 10, 64)
-					if err != nil {
-						log.Errorln(err)
-						e.parseFailures.WithLabelValues("cmconnectionstatus.html").Inc()
+					value, err = strconv.ParseFloat(number, 64)
+					value = e.round(value)
+				case 8:
+					number, unit := splitValueUnit(e.decimal(row[i]))
+					if unit != "dBmV" {
 						continue
 					}
-					channelLabel := fmt.Sprintf("%02d", channel)
-
-					for i, metric := range upstreamChannelMetrics {
-						var err error = nil
-						var value float64
-						var valueInt int64
-						var labelValues = []string{channelLabel}
-						switch i {
-						case 2:
-							if row[i] == "Locked" {
-								value = 1
-							} else {
-								value = 0
-							}
-						case 3, 8:
-							labelValues = append(labelValues, row[i])
-							value = 1
-						case 4:
-							if row[i] == "Bonded" {
-								value = 1
-							} else {
-								value = 0
-							}
-						case 5, 6:
-							parts := strings.Split(row[i], " ")
-							if len(parts) != 2 {
-								continue
-							}
-							valueInt, err = strconv.ParseInt(parts[0], 10, 64)
-							switch parts[1] {
-							case "Hz":
-							case "kHz":
-								valueInt = valueInt * 1000
-							default:
-								continue
-							}
-							value = float64(valueInt)
-						case 7:
-							parts := strings.Split(row[i], " ")
-							if len(parts) != 2 || parts[1] != "dBmV" {
-								continue
-							}
-							value, err = strconv.ParseFloat(parts[0], 64)
-						default:
-							continue
-						}
+					value, err = strconv.ParseFloat(number, 64)
+					value = e.round(value)
+				default:
+					continue
+				}
 
-						if err != nil {
-							log.Errorln(err)
-							e.parseFailures.WithLabelValues("cmconnectionstatus.html").Inc()
-							continue
-						}
-						ch <- prometheus.MustNewConstMetric(metric, prometheus.CounterValue, value, labelValues...)
-					}
+				if err == errAbsent {
+					continue
 				}
+				if err != nil {
+					e.parseFailed(&ParseError{"cmconnectionstatus.html", downstreamIndex, r + 2, i, parseReasonValue, err})
+					continue
+				}
+				ch <- prometheus.MustNewConstMetric(metric, valueType, value, labelValues...)
+			}
+
+			ch <- prometheus.MustNewConstMetric(e.descs.downstreamChannelTypeInfo, prometheus.GaugeValue, 1, channelLabel, row[3])
+			if plcColumn >= 0 && row[3] == "OFDM" {
+				var plcLocked float64
+				if row[plcColumn] == "Locked" {
+					plcLocked = 1
+				}
+				ch <- prometheus.MustNewConstMetric(e.descs.downstreamPLCLocked, prometheus.GaugeValue, plcLocked, channelLabel)
+			}
+			if snrColumn >= 0 && !unlocked {
+				if margin, ok := snrMargin(e.decimal(row[snrColumn]), e.decimal(row[7])); ok {
+					ch <- prometheus.MustNewConstMetric(e.descs.downstreamSNRMargin, prometheus.GaugeValue, e.round(margin), channelLabel)
+				}
+			}
+			if e.options.ChannelHealth {
+				ch <- prometheus.MustNewConstMetric(e.descs.downstreamChannelHealth, prometheus.GaugeValue, e.channelHealth(row), channelLabel)
+			}
+			if e.options.QualityScore {
+				snr := row[7]
+				if snrColumn >= 0 {
+					snr = row[snrColumn]
+				}
+				e.quality.addDownstream(e, row, snr)
+			}
+			if e.options.StringInfo {
+				if labelValues, found := stringInfoLabelValues(channelLabel, header, row); found {
+					ch <- prometheus.MustNewConstMetric(e.descs.downstreamStringInfo, prometheus.GaugeValue, 1, labelValues...)
+				}
+			}
 
+			downstreamChannels[channelLabel] = true
+			e.trackDownstream(ch, channelLabel, row)
+			if band, ok := channelBand(e.decimal(row[5]), e.decimal(row[6])); ok {
+				downstreamBands = append(downstreamBands, band)
+			}
+			if strings.Contains(row[3], "OFDM") {
+				ofdm = true
+			}
+			if row[4] == "Bonded" {
+				bonded++
+			}
+			channelTypes[channelTypeLabel(row[3])]++
+			if row[9] != "" {
+				downstreamModulations[row[9]] = true
+			}
+			if row[2] == "Locked" {
+				locked++
+				if row[4] == "Bonded" {
+					throughput += channelCapacity(row[3], e.decimal(row[6]), row[9])
+				}
+				if level, ok := parseLevel(e.decimal(row[8])); ok {
+					downstreamLevels = append(downstreamLevels, level)
+				}
+				snr := row[7]
+				if snrColumn >= 0 {
+					snr = row[snrColumn]
+				}
+				if value, ok := parseSNR(e.decimal(snr)); ok {
+					downstreamSNRs = append(downstreamSNRs, value)
+				}
 			}
 		}
 	}
+	e.pruneDownstream(downstreamChannels)
+	downstreamCount = len(downstreamChannels)
+	if e.options.ThroughputEstimate {
+		ch <- prometheus.MustNewConstMetric(e.descs.downstreamThroughput, prometheus.GaugeValue, throughput)
+	}
+	if downstreamCount > 0 {
+		ch <- prometheus.MustNewConstMetric(e.descs.downstreamLockedRatio, prometheus.GaugeValue, float64(locked)/float64(downstreamCount))
+	}
+	ch <- prometheus.MustNewConstMetric(e.descs.downstreamChannelOverlap, prometheus.GaugeValue, float64(overlappingBands(downstreamBands)))
+	ch <- prometheus.MustNewConstMetric(e.descs.downstreamDistinctModulations, prometheus.GaugeValue, float64(len(downstreamModulations)))
+	ch <- prometheus.MustNewConstMetric(e.descs.downstreamBondedActive, prometheus.GaugeValue, float64(bonded))
+	if len(downstreamSNRs) > 0 {
+		min, max := downstreamSNRs[0], downstreamSNRs[0]
+		for _, snr := range downstreamSNRs[1:] {
+			min, max = math.Min(min, snr), math.Max(max, snr)
+		}
+		ch <- prometheus.MustNewConstMetric(e.descs.downstreamSNRSpread, prometheus.GaugeValue, e.round(max-min))
+	}
+	for _, channelType := range downstreamChannelTypes {
+		ch <- prometheus.MustNewConstMetric(e.descs.downstreamChannelsByType, prometheus.GaugeValue, float64(channelTypes[channelType]), channelType)
+	}
+	buckets := e.options.ReceiveLevelBuckets
+	if len(buckets) == 0 {
+		buckets = defaultReceiveLevelBuckets
+	}
+	count, sum, counts := histogram(downstreamLevels, buckets)
+	ch <- prometheus.MustNewConstHistogram(e.descs.downstreamReceiveLevels, count, sum, counts)
 
-	return 1
+	// The variant tells DOCSIS 3.0 from 3.1 channel sets and the layout
+	// with additional columns from the original one.
+	variant := "docsis30"
+	if ofdm {
+		variant = "docsis31"
+	}
+	if extended {
+		variant += "_extended"
+	}
+	ch <- prometheus.MustNewConstMetric(e.descs.parserVariant, prometheus.GaugeValue, 1, variant)
+	return
+}
+
+// emitUpstream emits the upstream channel metrics and returns the number of
+// channels parsed.
+func (e *Exporter) emitUpstream(ch chan<- prometheus.Metric, tables [][][]string, upstreamIndex int) (upstreamCount int) {
+	// Firmware releases reporting the maximum transmit level do so in an
+	// additional column.
+	upstreamColumns := 9
+	maxLevelColumn := -1
+	header := tables[upstreamIndex][1]
+	if len(header) > upstreamColumns {
+		if i := selectColumn(header[upstreamColumns:], "Max"); i >= 0 {
+			maxLevelColumn = upstreamColumns + i
+		}
+		upstreamColumns = len(header)
+	}
+	locked := 0
+	for r, row := range tables[upstreamIndex][2:] {
+		if len(row) != upstreamColumns {
+			e.rowSkipped("cmconnectionstatus.html", upstreamIndex)
+			continue
+		}
+		if e.options.MaxChannels > 0 && upstreamCount >= e.options.MaxChannels {
+			e.channelsCapped("upstream")
+			break
+		}
+
+		channel, err := strconv.ParseInt(row[1], 10, 64)
+		if err != nil {
+			e.parseFailed(&ParseError{"cmconnectionstatus.html", upstreamIndex, r + 2, 1, parseReasonValue, err})
+			continue
+		}
+		channelLabel := fmt.Sprintf("%02d", channel)
+		upstreamCount++
+		if row[2] == "Locked" {
+			locked++
+		}
+		if e.options.QualityScore {
+			e.quality.addUpstream(row)
+		}
+
+		unlocked := e.options.ExcludeUnlocked && row[2] != "Locked"
+		for i, metric := range e.descs.upstream {
+			var err error = nil
+			var value float64
+			var valueInt int64
+			var labelValues = []string{channelLabel}
+			if unlocked && (i == 7 || i == 8) {
+				continue
+			}
+			switch i {
+			case 2:
+				if row[i] == "Locked" {
+					value = 1
+				} else {
+					value = 0
+				}
+			case 3, 8:
+				labelValues = append(labelValues, row[i])
+				value = 1
+			case 4:
+				if row[i] == "Bonded" {
+					value = 1
+				} else {
+					value = 0
+				}
+			case 5, 6:
+				number, unit := splitValueUnit(e.decimal(row[i]))
+				if number == "" || unit == "" {
+					continue
+				}
+				valueInt, err = strconv.ParseInt(number, 10, 64)
+				switch unit {
+				case "Hz":
+				case "kHz":
+					valueInt = valueInt * 1000
+				default:
+					continue
+				}
+				value = float64(valueInt)
+			case 7:
+				number, unit := splitValueUnit(e.decimal(row[i]))
+				if unit != "dBmV" {
+					continue
+				}
+				value, err = strconv.ParseFloat(number, 64)
+				value = e.round(value)
+			default:
+				continue
+			}
+
+			if err != nil {
+				e.parseFailed(&ParseError{"cmconnectionstatus.html", upstreamIndex, r + 2, i, parseReasonValue, err})
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(metric, e.statusValueType(), value, labelValues...)
+		}
+
+		maxLevel, ok := e.options.UpstreamMaxLevel, e.options.UpstreamMaxLevel != 0
+		if maxLevelColumn >= 0 {
+			if level, found := parseLevel(e.decimal(row[maxLevelColumn])); found {
+				maxLevel, ok = level, true
+			}
+		}
+		if level, found := parseLevel(e.decimal(row[7])); found && ok && !unlocked {
+			ch <- prometheus.MustNewConstMetric(e.descs.upstreamTransmitHeadroom, prometheus.GaugeValue, e.round(maxLevel-level), channelLabel)
+		}
+
+		if e.options.StringInfo {
+			if labelValues, found := stringInfoLabelValues(channelLabel, header, row); found {
+				ch <- prometheus.MustNewConstMetric(e.descs.upstreamStringInfo, prometheus.GaugeValue, 1, labelValues...)
+			}
+		}
+	}
+	if upstreamCount > 0 {
+		ch <- prometheus.MustNewConstMetric(e.descs.upstreamLockedRatio, prometheus.GaugeValue, float64(locked)/float64(upstreamCount))
+	}
+	return
+}
+
+// channelsCapped logs and counts dropping the channels of a direction beyond
+// Options.MaxChannels.
+func (e *Exporter) channelsCapped(direction string) {
+	log.Warnf("More than %d %s channels, ignoring the rest", e.options.MaxChannels, direction)
+	e.cappedChannels.WithLabelValues(direction).Inc()
+}
+
+// channelHealth scores a downstream channel row with one point each for
+// being locked, having its receive level within the configured range and
+// its SNR at or above the configured threshold.
+func (e *Exporter) channelHealth(row []string) (score float64) {
+	if row[2] == "Locked" {
+		score++
+	}
+
+	number, unit := splitValueUnit(e.decimal(row[8]))
+	if unit == "dBmV" {
+		level, err := strconv.ParseFloat(number, 64)
+		if err == nil && level >= e.options.HealthMinReceiveLevel && level <= e.options.HealthMaxReceiveLevel {
+			score++
+		}
+	}
+
+	number, unit = splitValueUnit(e.decimal(row[7]))
+	if unit == "dB" {
+		snr, err := strconv.ParseFloat(number, 64)
+		if err == nil && snr >= e.options.HealthMinSNR {
+			score++
+		}
+	}
+
+	return score
+}
+
+// decimal normalizes the decimal separator of a cell to a decimal point.
+func (e *Exporter) decimal(s string) string {
+	return normalizeDecimal(s, e.options.DecimalSeparator)
+}
+
+// statusValueType returns the value type of the channel status metrics.
+func (e *Exporter) statusValueType() prometheus.ValueType {
+	if e.options.LegacyCounterTypes {
+		return prometheus.CounterValue
+	}
+	return prometheus.GaugeValue
+}
+
+// round rounds a level, SNR or similar value to Options.RoundDecimals
+// decimals if Options.RoundValues is set.
+func (e *Exporter) round(value float64) float64 {
+	if !e.options.RoundValues {
+		return value
+	}
+	scale := math.Pow(10, float64(e.options.RoundDecimals))
+	return math.Round(value*scale) / scale
+}
+
+// channelBand returns the lower and upper frequency of a channel from its
+// center frequency and width, or false for unparsable or zero-width
+// channels.
+func channelBand(center, width string) ([2]float64, bool) {
+	values := [2]float64{}
+	for i, s := range []string{center, width} {
+		value, ok := parseFrequency(s)
+		if !ok {
+			return values, false
+		}
+		values[i] = value
+	}
+	if values[1] <= 0 {
+		return values, false
+	}
+	return [2]float64{values[0] - values[1]/2, values[0] + values[1]/2}, true
+}
+
+// Symbols per second and Hz of channel width. SC-QAM symbol rates are about
+// 0.87 of the width, OFDM loses about a tenth to cyclic prefix, guard bands
+// and pilots.
+const (
+	scQAMSymbolRateFactor = 0.87
+	ofdmSymbolRateFactor  = 0.9
+)
+
+// channelCapacity estimates the gross capacity of a channel in bit/s from its
+// type, width and modulation, or returns 0 if they can't be parsed.
+func channelCapacity(channelType, width, modulation string) float64 {
+	hz, ok := parseFrequency(width)
+	if !ok {
+		return 0
+	}
+	order, err := parseQAM(modulation)
+	if err != nil || order < 2 {
+		return 0
+	}
+	factor := scQAMSymbolRateFactor
+	if strings.Contains(channelType, "OFDM") {
+		factor = ofdmSymbolRateFactor
+	}
+	return hz * math.Log2(order) * factor
+}
+
+// parseFrequency parses a frequency like "591000000 Hz" or "6400 kHz" and
+// returns it in Hz.
+func parseFrequency(s string) (float64, bool) {
+	number, unit := splitValueUnit(s)
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, false
+	}
+	switch unit {
+	case "Hz":
+	case "kHz":
+		value *= 1000
+	default:
+		return 0, false
+	}
+	return value, true
+}
+
+// histogram returns the count, sum and cumulative bucket counts of values.
+func histogram(values, buckets []float64) (uint64, float64, map[float64]uint64) {
+	sum := 0.0
+	counts := map[float64]uint64{}
+	for _, b := range buckets {
+		counts[b] = 0
+	}
+	for _, v := range values {
+		sum += v
+		for _, b := range buckets {
+			if v <= b {
+				counts[b]++
+			}
+		}
+	}
+	return uint64(len(values)), sum, counts
+}
+
+// downstreamChannelTypes are the type label values of the channels by type
+// metric, all of which are exported each scrape.
+var downstreamChannelTypes = []string{"SC-QAM", "OFDM", "other"}
+
+// channelTypeLabel maps a channel type to one of downstreamChannelTypes, to
+// keep the type label bounded.
+func channelTypeLabel(channelType string) string {
+	switch {
+	case channelType == "SC-QAM":
+		return "SC-QAM"
+	case strings.Contains(channelType, "OFDM"):
+		return "OFDM"
+	}
+	return "other"
+}
+
+// overlappingBands returns the number of pairs of overlapping frequency
+// bands.
+func overlappingBands(bands [][2]float64) int {
+	n := 0
+	for i := range bands {
+		for j := i + 1; j < len(bands); j++ {
+			if bands[i][0] < bands[j][1] && bands[j][0] < bands[i][1] {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// parseLevel parses a level like "45.5 dBmV".
+func parseLevel(s string) (float64, bool) {
+	number, unit := splitValueUnit(s)
+	if unit != "dBmV" {
+		return 0, false
+	}
+	value, err := strconv.ParseFloat(number, 64)
+	return value, err == nil
+}
+
+// snrMargin returns the difference between a measured SNR and the SNR
+// threshold, both given like "38.5 dB".
+func snrMargin(measured, threshold string) (float64, bool) {
+	values := [2]float64{}
+	for i, s := range []string{measured, threshold} {
+		number, unit := splitValueUnit(s)
+		if unit != "dB" {
+			return 0, false
+		}
+		value, err := strconv.ParseFloat(number, 64)
+		if err != nil {
+			return 0, false
+		}
+		values[i] = value
+	}
+	return values[0] - values[1], true
+}
+
+var nonAlphanumeric = regexp.MustCompile("[^a-z0-9]+")
+
+// provisioningStepName turns a startup procedure label like "Boot State" into
+// a step label value like "boot_state".
+func provisioningStepName(label string) string {
+	return strings.Trim(nonAlphanumeric.ReplaceAllString(strings.ToLower(label), "_"), "_")
+}
+
+// provisioningStepDone reports whether a startup procedure status denotes a
+// completed step.
+func provisioningStepDone(status string) float64 {
+	switch strings.ToLower(status) {
+	case "ok", "done", "completed", "complete", "enabled", "allowed", "operational":
+		return 1
+	}
+	return 0
 }
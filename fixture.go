@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+//go:embed fixture.html
+var fixturePage []byte
+
+// fixtureMetrics is the golden list of the metrics the built-in collector
+// emits for fixture.html, one `name{label="value"}` per line.
+//
+//go:embed fixture-metrics.txt
+var fixtureMetrics string
+
+// checkFixture reports whether the embedded cmconnectionstatus.html fixture
+// still yields the expected metrics, to notice parser regressions e.g. after
+// dependency updates.
+func checkFixture() bool {
+	metrics, err := fixtureMetricNames(fixturePage)
+	if err != nil {
+		return false
+	}
+	return strings.Join(metrics, "\n")+"\n" == fixtureMetrics
+}
+
+// collectorFunc is an unchecked collector collecting the metrics sent by the
+// function.
+type collectorFunc func(ch chan<- prometheus.Metric)
+
+func (f collectorFunc) Describe(ch chan<- *prometheus.Desc) {}
+
+func (f collectorFunc) Collect(ch chan<- prometheus.Metric) {
+	f(ch)
+}
+
+// fixtureMetricNames returns the metrics the built-in collector emits for a
+// cmconnectionstatus.html page without namespace, like
+// `downstream_locked{channel="01"}`, sorted.
+func fixtureMetricNames(page []byte) ([]string, error) {
+	tables, headings, err := parseHeadedTables(ioutil.NopCloser(bytes.NewReader(page)), false)
+	if err != nil {
+		return nil, err
+	}
+
+	check := &Exporter{
+		options:          Options{DownstreamTableIndex: -1, UpstreamTableIndex: -1},
+		descs:            newDescriptors("", Subsystems{}, nil),
+		downstreamStates: map[string]*downstreamState{},
+		parseFailures:    prometheus.NewCounterVec(prometheus.CounterOpts{Name: "parse_errors_total"}, []string{"file", "reason"}),
+		skippedRows:      prometheus.NewCounterVec(prometheus.CounterOpts{Name: "skipped_rows_total"}, []string{"file", "table"}),
+		lastErrorInfo:    prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "last_error_info"}, []string{"error", "file"}),
+	}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectorFunc(func(ch chan<- prometheus.Metric) {
+		check.emitConnectionStatus(ch, tables, headings)
+	}))
+	families, err := registry.Gather()
+	if err != nil {
+		return nil, err
+	}
+
+	// The families and their labels are sorted by name already.
+	names := []string{}
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			labels := []string{}
+			for _, l := range m.GetLabel() {
+				labels = append(labels, fmt.Sprintf("%s=%q", l.GetName(), l.GetValue()))
+			}
+			name := family.GetName()
+			if len(labels) > 0 {
+				name += "{" + strings.Join(labels, ",") + "}"
+			}
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFixtureMetrics(t *testing.T) {
+	got, err := fixtureMetricNames(fixturePage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Split(strings.TrimSuffix(fixtureMetrics, "\n"), "\n")
+	missing, unexpected := map[string]bool{}, map[string]bool{}
+	for _, name := range expected {
+		missing[name] = true
+	}
+	for _, name := range got {
+		if missing[name] {
+			delete(missing, name)
+		} else {
+			unexpected[name] = true
+		}
+	}
+	for name := range missing {
+		t.Errorf("Expected %s for fixture.html, update fixture-metrics.txt if it was dropped on purpose", name)
+	}
+	for name := range unexpected {
+		t.Errorf("Unexpected %s for fixture.html, update fixture-metrics.txt if it was added on purpose", name)
+	}
+	if !checkFixture() {
+		t.Error("Expected the fixture check to pass")
+	}
+}
+
+func TestFixtureCheck(t *testing.T) {
+	original := fixturePage
+	t.Cleanup(func() { fixturePage = original })
+
+	upstream := `<tr><td>1</td><td>1</td><td>Locked</td><td>SC-QAM</td><td>Bonded</td><td>51000000 Hz</td><td>6400000 Hz</td><td>45.0 dBmV</td><td>64QAM</td></tr>`
+	for _, test := range []struct {
+		name     string
+		page     string
+		expected float64
+	}{
+		{"fixture", string(original), 1},
+		{"dropped channel", strings.Replace(string(original), upstream, "", 1), 0},
+		{"renamed channel", strings.Replace(string(original), "<td>1</td><td>1</td><td>Locked</td><td>SC-QAM</td><td>Bonded</td><td>51000000 Hz</td>", "<td>1</td><td>2</td><td>Locked</td><td>SC-QAM</td><td>Bonded</td><td>51000000 Hz</td>", 1), 0},
+		{"garbled values", strings.Replace(string(original), "dBmV", "", -1), 0},
+		{"no tables", "<html></html>", 0},
+		{"empty", "", 0},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			fixturePage = []byte(test.page)
+			got := series(t, newTestExporter(t, "http://127.0.0.1:1/", defaultOptions()))
+			if value := got["tc4400_exporter_fixture_check"]; value != test.expected {
+				t.Errorf("Expected a fixture check of %g, got %g", test.expected, value)
+			}
+		})
+	}
+}
@@ -1,10 +1,14 @@
 module github.com/markuslindenberg/tc4400_exporter
 
-go 1.13
+go 1.16
 
 require (
+	github.com/golang/snappy v1.0.0
 	github.com/prometheus/client_golang v1.11.1
+	github.com/prometheus/client_model v0.2.0
 	github.com/prometheus/common v0.26.0
 	golang.org/x/net v0.0.0-20200625001655-4c5254603344
+	google.golang.org/protobuf v1.26.0-rc.1
 	gopkg.in/alecthomas/kingpin.v2 v2.2.6
+	gopkg.in/yaml.v2 v2.3.0
 )
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+// GraphiteWriter periodically gathers metrics and sends them to a Graphite
+// carbon endpoint in the plaintext protocol.
+type GraphiteWriter struct {
+	address  string
+	interval time.Duration
+	gatherer prometheus.Gatherer
+}
+
+func NewGraphiteWriter(address string, interval time.Duration, gatherer prometheus.Gatherer) *GraphiteWriter {
+	return &GraphiteWriter{
+		address:  address,
+		interval: interval,
+		gatherer: gatherer,
+	}
+}
+
+// Run sends the metrics once per interval until the process exits.
+func (w *GraphiteWriter) Run() {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := w.send(); err != nil {
+			log.Errorln("Sending to Graphite at", w.address, "failed:", err)
+		}
+		<-ticker.C
+	}
+}
+
+func (w *GraphiteWriter) send() error {
+	families, err := w.gatherer.Gather()
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	now := time.Now()
+	for _, ts := range toTimeSeries(families, now) {
+		if math.IsNaN(ts.value) || math.IsInf(ts.value, 0) {
+			continue
+		}
+		fmt.Fprintf(&buf, "%s %s %d\n", graphitePath(ts.labels), strconv.FormatFloat(ts.value, 'g', -1, 64), now.Unix())
+	}
+
+	conn, err := net.DialTimeout("tcp", w.address, w.interval)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(w.interval))
+	_, err = conn.Write(buf.Bytes())
+	return err
+}
+
+// graphiteUnsafe matches the characters not allowed in a Graphite path node.
+var graphiteUnsafe = regexp.MustCompile(`[^a-zA-Z0-9_:-]`)
+
+// graphitePath returns the Graphite path of a series: the metric name
+// followed by the name and value of each label, sorted by label name.
+func graphitePath(labels []label) string {
+	var name string
+	var buf bytes.Buffer
+	for _, l := range labels {
+		if l.name == "__name__" {
+			name = l.value
+			continue
+		}
+		buf.WriteString("." + l.name + "." + graphiteUnsafe.ReplaceAllString(l.value, "_"))
+	}
+	return name + buf.String()
+}
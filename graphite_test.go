@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"math"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestGraphitePath(t *testing.T) {
+	for _, test := range []struct {
+		labels   []label
+		expected string
+	}{
+		{[]label{{"__name__", "tc4400_up"}}, "tc4400_up"},
+		{[]label{{"__name__", "tc4400_downstream_locked"}, {"channel", "01"}}, "tc4400_downstream_locked.channel.01"},
+		{[]label{{"__name__", "tc4400_downstream_channel_type_info"}, {"channel", "33"}, {"type", "OFDM PLC"}}, "tc4400_downstream_channel_type_info.channel.33.type.OFDM_PLC"},
+		{[]label{{"__name__", "tc4400_cm_ip_info"}, {"address", "2001:db8::1"}, {"version", "4.6"}}, "tc4400_cm_ip_info.address.2001:db8::1.version.4_6"},
+	} {
+		if got := graphitePath(test.labels); got != test.expected {
+			t.Errorf("Expected %s, got %s", test.expected, got)
+		}
+	}
+}
+
+func TestGraphiteWriter(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_gauge", Help: "Test gauge."}, []string{"channel"})
+	gauge.WithLabelValues("01").Set(1.5)
+	gauge.WithLabelValues("a/b").Set(-2)
+	// Not representable in Graphite, so skipped.
+	gauge.WithLabelValues("nan").Set(math.NaN())
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_seconds", Help: "Test histogram.", Buckets: []float64{1}})
+	histogram.Observe(0.5)
+	registry.MustRegister(gauge, histogram)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	received := make(chan []string)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			close(received)
+			return
+		}
+		defer conn.Close()
+		lines := []string{}
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		received <- lines
+	}()
+
+	before := time.Now().Unix()
+	if err := NewGraphiteWriter(listener.Addr().String(), time.Second, registry).send(); err != nil {
+		t.Fatal(err)
+	}
+	after := time.Now().Unix()
+	lines := <-received
+	sort.Strings(lines)
+
+	expected := []string{
+		"test_gauge.channel.01 1.5",
+		"test_gauge.channel.a_b -2",
+		"test_seconds_bucket.le.1 1",
+		"test_seconds_bucket.le._Inf 1",
+		"test_seconds_count 1",
+		"test_seconds_sum 0.5",
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d lines, got %q", len(expected), lines)
+	}
+	// Lines are the path, the value and the timestamp in seconds.
+	line := regexp.MustCompile(`^[a-zA-Z0-9_:.-]+ \S+ \d+$`)
+	for i, got := range lines {
+		if !line.MatchString(got) {
+			t.Errorf("Malformed line %q", got)
+		}
+		fields := strings.Fields(got)
+		if path := strings.Join(fields[:len(fields)-1], " "); path != expected[i] {
+			t.Errorf("Expected %s, got %s", expected[i], got)
+		}
+		if timestamp, err := strconv.ParseInt(fields[len(fields)-1], 10, 64); err != nil || timestamp < before || timestamp > after {
+			t.Errorf("Expected a timestamp between %d and %d, got %q", before, after, got)
+		}
+	}
+
+	listener.Close()
+	if err := NewGraphiteWriter(listener.Addr().String(), time.Second, registry).send(); err == nil {
+		t.Error("Expected an error without a carbon receiver")
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil/promlint"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// lintExceptions are metrics keeping names promlint objects to.
+var lintExceptions = map[string]bool{
+	// Not the count of a summary, but of the attached devices.
+	"tc4400_cm_cpe_count": true,
+}
+
+func TestLint(t *testing.T) {
+
+	config, err := parseConfig(defaultConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name  string
+		tweak func(*Options)
+	}{
+		{"defaults", nil},
+		// Enable every optional metric.
+		{"all collectors", func(o *Options) {
+			o.CollectCMSWInfo = true
+			o.DeviceInfo = true
+			o.ChannelHealth = true
+			o.QualityScore = true
+			o.StringInfo = true
+			o.ThroughputEstimate = true
+			o.ScrapeSamples = true
+			o.SelfTargetLabel = true
+			o.ReceiveLevelBuckets = defaultReceiveLevelBuckets
+		}},
+		{"config", func(o *Options) { o.Config = config }},
+		{"subsystems", func(o *Options) {
+			o.Subsystems = Subsystems{Downstream: "docsis_down", Upstream: "docsis_up", Network: "lan"}
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			m := newModem(t, map[string]string{
+				"cmconnectionstatus.html": string(fixturePage),
+				"statsifc.html":           readTestdata(t, "statsifc.html"),
+				"cmswinfo.html":           readTestdata(t, "cmswinfo.html"),
+			})
+			options := defaultOptions()
+			if test.tweak != nil {
+				test.tweak(&options)
+			}
+			e := newTestExporter(t, m.URL, options)
+
+			// Also check the metrics not emitted for the fixtures.
+			descs := make(chan *prometheus.Desc)
+			go func() {
+				e.Describe(descs)
+				close(descs)
+			}()
+			for desc := range descs {
+				if strings.Contains(desc.String(), `help: ""`) {
+					t.Errorf("Empty help text: %s", desc)
+				}
+			}
+
+			registry := prometheus.NewPedanticRegistry()
+			registry.MustRegister(e, newStartTimeMetric(defaultNamespace, time.Now()))
+			families, err := registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			problems, err := promlint.NewWithMetricFamilies(families).Lint()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, p := range problems {
+				if !lintExceptions[p.Metric] {
+					t.Errorf("%s: %s", p.Metric, p.Text)
+				}
+			}
+			// promlint only finds missing help, not empty help.
+			for _, family := range families {
+				if family.GetHelp() == "" {
+					t.Errorf("%s: empty help text", family.GetName())
+				}
+				// Copied help texts must name the direction of the metric.
+				if (strings.Contains(family.GetName(), "_upstream_") && strings.Contains(family.GetHelp(), "Downstream")) ||
+					(strings.Contains(family.GetName(), "_downstream_") && strings.Contains(family.GetHelp(), "Upstream")) {
+					t.Errorf("%s: help text %q of the other direction", family.GetName(), family.GetHelp())
+				}
+			}
+		})
+	}
+}
+
+func TestMetricTypes(t *testing.T) {
+	config, err := parseConfig(defaultConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages := map[string]string{
+		"cmconnectionstatus.html": string(fixturePage),
+		"statsifc.html":           readTestdata(t, "statsifc.html"),
+		"cmswinfo.html":           readTestdata(t, "cmswinfo.html"),
+	}
+	for _, tweak := range []func(*Options){
+		func(o *Options) {
+			o.CollectCMSWInfo = true
+			o.DeviceInfo = true
+			o.ChannelHealth = true
+			o.QualityScore = true
+			o.StringInfo = true
+			o.ThroughputEstimate = true
+			o.ScrapeSamples = true
+		},
+		func(o *Options) { o.Config = config },
+	} {
+		m := newModem(t, pages)
+		options := defaultOptions()
+		tweak(&options)
+		registry := prometheus.NewPedanticRegistry()
+		registry.MustRegister(newTestExporter(t, m.URL, options))
+		families, err := registry.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, family := range families {
+			name, metricType := family.GetName(), family.GetType()
+			// Counters, and only counters, end in _total.
+			if counter := metricType == dto.MetricType_COUNTER; counter != strings.HasSuffix(name, "_total") {
+				t.Errorf("%s: unexpected type %s", name, metricType)
+			}
+			for _, m := range family.GetMetric() {
+				value := m.GetGauge().GetValue()
+				switch {
+				case strings.HasSuffix(name, "_ratio") && (metricType != dto.MetricType_GAUGE || value < 0 || value > 1):
+					t.Errorf("%s: expected a gauge between 0 and 1, got %s %g", name, metricType, value)
+				case strings.HasSuffix(name, "_info") && (metricType != dto.MetricType_GAUGE || value != 1):
+					t.Errorf("%s: expected a gauge of 1, got %s %g", name, metricType, value)
+				}
+			}
+		}
+	}
+
+	// Boolean status values are gauges.
+	got := map[string]dto.MetricType{}
+	m := newModem(t, pages)
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(newTestExporter(t, m.URL, defaultOptions()))
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		got[family.GetName()] = family.GetType()
+	}
+	for _, name := range []string{"tc4400_downstream_locked", "tc4400_downstream_bonded", "tc4400_upstream_locked", "tc4400_upstream_bonded", "tc4400_up"} {
+		if metricType, ok := got[name]; !ok || metricType != dto.MetricType_GAUGE {
+			t.Errorf("%s: expected a gauge, got %s (present: %t)", name, metricType, ok)
+		}
+	}
+}
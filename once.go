@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+// Exit codes of --once.
+const (
+	onceHealthy     = 0
+	onceUnreachable = 1
+	onceDegraded    = 2
+)
+
+// onceStatusPage is the page a modem has to serve to count as up. The other
+// pages only add optional metrics.
+const onceStatusPage = "cmconnectionstatus.html"
+
+// checkOnce scrapes every URI once and writes one "target=... up=...
+// parse_errors=... optional_fetch_errors=... failed_pages=..." line per URI
+// to w, failed_pages listing the pages that couldn't be fetched separated by
+// commas. It returns onceUnreachable if any modem is down, i.e. its up metric
+// is 0 or its connection status page couldn't be fetched, otherwise
+// onceDegraded if any page had parse errors or an optional page couldn't be
+// fetched, otherwise onceHealthy.
+func checkOnce(w io.Writer, uris []string, timeout time.Duration, options Options) int {
+	options.PollInterval = 0
+	code := onceHealthy
+	for _, uri := range uris {
+		exporter, err := NewExporter(uri, timeout, options)
+		if err != nil {
+			log.Errorln(err)
+			fmt.Fprintf(w, "target=%s up=0 parse_errors=0 optional_fetch_errors=0 failed_pages=\n", scrapeTarget(uri))
+			code = onceUnreachable
+			continue
+		}
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(exporter)
+		families, err := registry.Gather()
+
+		up := 0.0
+		for _, family := range families {
+			if family.GetName() == prometheus.BuildFQName(options.Namespace, "", "up") && len(family.GetMetric()) == 1 {
+				up = family.GetMetric()[0].GetGauge().GetValue()
+			}
+		}
+		parseErrors := exporter.scrapeParseErrors
+		if err != nil && parseErrors == 0 {
+			// Metrics that can't be gathered are malformed page contents.
+			parseErrors = 1
+		}
+		failedPages, optionalFetchErrors := []string{}, 0
+		for _, page := range exporter.scrapeFailedPages {
+			if contains(failedPages, page) {
+				continue
+			}
+			failedPages = append(failedPages, page)
+			if page == onceStatusPage {
+				up = 0
+			} else {
+				optionalFetchErrors++
+			}
+		}
+		fmt.Fprintf(w, "target=%s up=%g parse_errors=%d optional_fetch_errors=%d failed_pages=%s\n", scrapeTarget(uri), up, parseErrors, optionalFetchErrors, strings.Join(failedPages, ","))
+
+		switch {
+		case up != 1:
+			code = onceUnreachable
+		case (parseErrors > 0 || optionalFetchErrors > 0) && code == onceHealthy:
+			code = onceDegraded
+		}
+	}
+	return code
+}
+
+// contains reports whether s is one of list.
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
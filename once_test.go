@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckOnce(t *testing.T) {
+	const (
+		status = "cmconnectionstatus.html"
+		stats  = "statsifc.html"
+		info   = "cmswinfo.html"
+	)
+	pages := map[string]string{
+		status: string(fixturePage),
+		stats:  readTestdata(t, "statsifc.html"),
+		info:   readTestdata(t, "cmswinfo.html"),
+	}
+	// without returns the pages without the given ones.
+	without := func(files ...string) map[string]string {
+		result := map[string]string{}
+		for file, page := range pages {
+			result[file] = page
+		}
+		for _, file := range files {
+			delete(result, file)
+		}
+		return result
+	}
+	stopped := httptest.NewServer(nil)
+	stopped.Close()
+
+	for _, test := range []struct {
+		name  string
+		pages map[string]string
+		tweak func(*Options)
+		// uri replaces the URI of the fake modem if set.
+		uri      string
+		expected string
+		code     int
+	}{
+		{"healthy", pages, nil, "", "up=1 parse_errors=0 optional_fetch_errors=0 failed_pages=", onceHealthy},
+		{"statsifc missing", without(stats), nil, "", "up=1 parse_errors=0 optional_fetch_errors=1 failed_pages=statsifc.html", onceDegraded},
+		{"cmswinfo missing", without(info), func(o *Options) { o.CollectCMSWInfo = true }, "", "up=1 parse_errors=0 optional_fetch_errors=1 failed_pages=cmswinfo.html", onceDegraded},
+		{"optional pages missing", without(stats, info), func(o *Options) { o.CollectCMSWInfo = true }, "", "up=1 parse_errors=0 optional_fetch_errors=2 failed_pages=statsifc.html,cmswinfo.html", onceDegraded},
+		{"parse errors", map[string]string{status: readTestdata(t, "malformed-downstream.html"), stats: pages[stats]}, nil, "", "up=1 parse_errors=1 optional_fetch_errors=0 failed_pages=", onceDegraded},
+		{"connection status missing", without(status), nil, "", "up=0 parse_errors=0 optional_fetch_errors=0 failed_pages=cmconnectionstatus.html", onceUnreachable},
+		{"too few channels", pages, func(o *Options) { o.MinDownstreamChannels = 3 }, "", "up=0 parse_errors=0 optional_fetch_errors=0 failed_pages=", onceUnreachable},
+		{"unreachable", pages, nil, stopped.URL, "up=0 parse_errors=0 optional_fetch_errors=1 failed_pages=statsifc.html,cmconnectionstatus.html", onceUnreachable},
+		{"invalid URI", pages, nil, "%zz", "up=0 parse_errors=0 optional_fetch_errors=1 failed_pages=statsifc.html,cmconnectionstatus.html", onceUnreachable},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			uri := test.uri
+			if uri == "" {
+				uri = newModem(t, test.pages).URL
+			}
+			options := defaultOptions()
+			if test.tweak != nil {
+				test.tweak(&options)
+			}
+			var out bytes.Buffer
+			code := checkOnce(&out, []string{uri}, time.Second, options)
+			if code != test.code {
+				t.Errorf("Expected exit code %d, got %d", test.code, code)
+			}
+			if expected := fmt.Sprintf("target=%s %s\n", scrapeTarget(uri), test.expected); out.String() != expected {
+				t.Errorf("Expected %q, got %q", expected, out.String())
+			}
+		})
+	}
+}
+
+func TestCheckOnceTargets(t *testing.T) {
+	healthy := newModem(t, map[string]string{
+		"cmconnectionstatus.html": string(fixturePage),
+		"statsifc.html":           readTestdata(t, "statsifc.html"),
+	})
+	degraded := newModem(t, map[string]string{"cmconnectionstatus.html": string(fixturePage)})
+	down := newModem(t, map[string]string{"statsifc.html": readTestdata(t, "statsifc.html")})
+	for _, test := range []struct {
+		name string
+		uris []string
+		code int
+	}{
+		{"healthy", []string{healthy.URL, healthy.URL}, onceHealthy},
+		{"degraded", []string{healthy.URL, degraded.URL, healthy.URL}, onceDegraded},
+		// A modem down outweighs one degraded in any order.
+		{"down", []string{down.URL, degraded.URL}, onceUnreachable},
+		{"down last", []string{degraded.URL, healthy.URL, down.URL}, onceUnreachable},
+	} {
+		var out bytes.Buffer
+		if code := checkOnce(&out, test.uris, time.Second, defaultOptions()); code != test.code {
+			t.Errorf("%s: expected exit code %d, got %d", test.name, test.code, code)
+		}
+		if lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n"); len(lines) != len(test.uris) {
+			t.Errorf("%s: expected a line per target, got %q", test.name, out.String())
+		}
+	}
+}
@@ -2,45 +2,269 @@ package main
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"io"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"golang.org/x/net/html"
 	"golang.org/x/net/html/atom"
 )
 
-func parseTables(r io.ReadCloser) (tables [][][]string, err error) {
+// Reasons for parse failures, used as label values and therefore kept to a
+// small fixed set.
+const (
+	parseReasonHTML  = "html"
+	parseReasonTable = "table"
+	parseReasonValue = "value"
+)
+
+// ParseError describes where and why parsing a page failed. Table, Row and
+// Column are -1 where they don't apply.
+type ParseError struct {
+	File   string
+	Table  int
+	Row    int
+	Column int
+	Reason string
+	Err    error
+}
+
+func (err *ParseError) Error() string {
+	location := err.File
+	if err.Table >= 0 {
+		location += fmt.Sprintf(" table %d", err.Table)
+	}
+	if err.Row >= 0 {
+		location += fmt.Sprintf(" row %d", err.Row)
+	}
+	if err.Column >= 0 {
+		location += fmt.Sprintf(" column %d", err.Column)
+	}
+	return fmt.Sprintf("Parsing %s failed (%s): %v", location, err.Reason, err.Err)
+}
+
+func (err *ParseError) Unwrap() error {
+	return err.Err
+}
+
+// parseTables returns the rows and cells of all tables of a page in document
+// order, a table nested in a cell of another table following the outer one.
+// With skipNested only top-level tables are returned, so tables nested in a
+// cell of another table don't shift the indices of the tables following it.
+func parseTables(r io.ReadCloser, skipNested bool) (tables [][][]string, err error) {
+	tables, _, err = parseHeadedTables(r, skipNested)
+	return tables, err
+}
+
+// headingAtoms are the elements whose text is taken as heading of the table
+// following them.
+var headingAtoms = map[atom.Atom]bool{
+	atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+	atom.B: true, atom.Strong: true,
+}
+
+// parseHeadedTables works like parseTables and also returns the text of the
+// heading or bold element preceding each table, or an empty string for
+// tables without a heading of their own.
+func parseHeadedTables(r io.ReadCloser, skipNested bool) (tables [][][]string, headings []string, err error) {
 	doc, err := html.Parse(r)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	tables = [][][]string{}
+	headings = []string{}
+	heading := ""
 	n := doc
 	for {
-		if n.Type == html.ElementNode && n.DataAtom == atom.Table {
-			tables = append(tables, parseTable(n))
+		if n.Type == html.ElementNode && (n.DataAtom == atom.Table || nodeRole(n) == "table") {
+			if n.DataAtom == atom.Table {
+				tables = append(tables, parseTable(n))
+			} else {
+				tables = append(tables, parseRoleTable(n))
+			}
+			headings = append(headings, heading)
+			heading = ""
+			if !skipNested && n.FirstChild != nil {
+				n = n.FirstChild
+				continue
+			}
+		} else if n.Type == html.ElementNode && headingAtoms[n.DataAtom] {
+			heading = nodeText(n)
 		} else if n.FirstChild != nil {
 			n = n.FirstChild
 			continue
 		}
 
-		if n.NextSibling != nil {
-			n = n.NextSibling
-		} else if n.Parent != doc && n.Parent.NextSibling != nil {
-			n = n.Parent.NextSibling
-		} else {
+		for n != doc && n.NextSibling == nil {
+			n = n.Parent
+		}
+		if n == doc {
 			break
 		}
+		n = n.NextSibling
 	}
 
-	return tables, nil
+	return tables, headings, nil
+}
+
+// nodeRole returns the ARIA role attribute of an element node in lower
+// case, or an empty string.
+func nodeRole(n *html.Node) string {
+	if n.Type != html.ElementNode {
+		return ""
+	}
+	for _, a := range n.Attr {
+		if a.Key == "role" {
+			return strings.ToLower(strings.TrimSpace(a.Val))
+		}
+	}
+	return ""
+}
+
+// roleCells are the ARIA roles of the cells of a row.
+var roleCells = map[string]bool{"cell": true, "gridcell": true, "columnheader": true, "rowheader": true}
+
+// parseRoleTable returns the rows and cells of an element with role "table"
+// like parseTable does for a <table>, for pages building tables from
+// elements like <div> with role "row" and "cell", possibly grouped by role
+// "rowgroup".
+func parseRoleTable(tableNode *html.Node) (table [][]string) {
+	table = [][]string{}
+	var cells func(*html.Node, *[]string)
+	cells = func(n *html.Node, row *[]string) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if roleCells[nodeRole(c)] {
+				*row = append(*row, nodeText(c))
+			} else if nodeRole(c) != "table" {
+				cells(c, row)
+			}
+		}
+	}
+	var rows func(*html.Node)
+	rows = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			switch nodeRole(c) {
+			case "row":
+				row := []string{}
+				cells(c, &row)
+				table = append(table, row)
+			case "table":
+				// Nested tables are not part of this one.
+			default:
+				rows(c)
+			}
+		}
+	}
+	rows(tableNode)
+	return table
+}
+
+// nodeText returns the trimmed text content of a node.
+func nodeText(n *html.Node) string {
+	var content bytes.Buffer
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			content.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return strings.TrimSpace(content.String())
+}
+
+// parseTableStream calls fn with the cells of every row of the top-level tables
+// of a page as it is read, without building the whole DOM like parseTables.
+// For <table> elements it yields the same rows as parseTables skipping nested
+// tables, but it ignores ARIA role tables, so on pages with those the indices
+// of the following tables differ. table and row are the indices of the table
+// and of the row within it.
+func parseTableStream(r io.Reader, fn func(table, row int, cells []string)) error {
+	z := html.NewTokenizer(r)
+	depth, table, row := 0, -1, 0
+	var cells []string
+	inRow, inCell := false, false
+	var content bytes.Buffer
+
+	endCell := func() {
+		if inCell {
+			cells = append(cells, strings.TrimSpace(content.String()))
+			inCell = false
+		}
+	}
+	endRow := func() {
+		endCell()
+		if inRow {
+			fn(table, row, cells)
+			row++
+			inRow = false
+		}
+	}
+
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			if z.Err() == io.EOF {
+				return nil
+			}
+			return z.Err()
+		case html.TextToken:
+			if inCell {
+				content.Write(z.Text())
+			}
+		case html.StartTagToken, html.EndTagToken:
+			name, _ := z.TagName()
+			a := atom.Lookup(name)
+			if a == atom.Table {
+				if tt == html.StartTagToken {
+					depth++
+					if depth == 1 {
+						table++
+						row = 0
+					}
+				} else if depth > 0 {
+					if depth == 1 {
+						endRow()
+					}
+					depth--
+				}
+				continue
+			}
+			if depth != 1 {
+				continue
+			}
+			switch {
+			case a == atom.Tr && tt == html.StartTagToken:
+				endRow()
+				cells = []string{}
+				inRow = true
+			case a == atom.Tr:
+				endRow()
+			case (a == atom.Td || a == atom.Th) && tt == html.StartTagToken:
+				endCell()
+				if !inRow {
+					cells = []string{}
+					inRow = true
+				}
+				content.Reset()
+				inCell = true
+			case a == atom.Td || a == atom.Th:
+				endCell()
+			}
+		}
+	}
 }
 
 func parseTable(tableNode *html.Node) (table [][]string) {
 	table = [][]string{}
 
-	var contentBuffer bytes.Buffer
 	bodyNode := tableNode.FirstChild
 	for {
 		if bodyNode == nil {
@@ -60,28 +284,7 @@ func parseTable(tableNode *html.Node) (table [][]string) {
 							break
 						}
 						if cellNode.Type == html.ElementNode && (cellNode.DataAtom == atom.Th || cellNode.DataAtom == atom.Td) {
-							contentBuffer.Reset()
-							contentNode := cellNode.FirstChild
-							for {
-								if contentNode == nil {
-									break
-								}
-								if contentNode.Type == html.TextNode {
-									contentBuffer.WriteString(contentNode.Data)
-								} else if contentNode.FirstChild != nil {
-									contentNode = contentNode.FirstChild
-									continue
-								}
-
-								if contentNode.NextSibling != nil {
-									contentNode = contentNode.NextSibling
-								} else if contentNode.Parent != cellNode {
-									contentNode = contentNode.Parent.NextSibling
-								} else {
-									contentNode = nil
-								}
-							}
-							row = append(row, strings.TrimSpace(contentBuffer.String()))
+							row = append(row, nodeText(cellNode))
 						}
 						cellNode = cellNode.NextSibling
 					}
@@ -94,3 +297,199 @@ func parseTable(tableNode *html.Node) (table [][]string) {
 	}
 	return table
 }
+
+// tableToKV converts a two-column key/value table into a map keyed by the
+// first column. Rows with fewer than two cells are skipped, and if a key
+// appears more than once the last value wins.
+func tableToKV(table [][]string) map[string]string {
+	kv := map[string]string{}
+	for _, row := range table {
+		if len(row) < 2 || row[0] == "" {
+			continue
+		}
+		kv[row[0]] = row[1]
+	}
+	return kv
+}
+
+// groupSeparators are the thousands separators accepted by parseGroupedInt.
+const groupSeparators = ", .'\u00a0\u202f"
+
+// parseGroupedInt parses an integer that may use a thousands separator as in
+// "1,234,567" or "1 234 567". A separator is only accepted between groups of
+// exactly three digits, so a decimal comma such as "1,5" is rejected.
+func parseGroupedInt(s string) (int64, error) {
+	i := strings.IndexAny(s, groupSeparators)
+	if i < 0 {
+		return strconv.ParseInt(s, 10, 64)
+	}
+	sep, _ := utf8.DecodeRuneInString(s[i:])
+
+	groups := strings.Split(s, string(sep))
+	digits := strings.TrimPrefix(groups[0], "-")
+	if len(digits) < 1 || len(digits) > 3 {
+		return 0, &strconv.NumError{Func: "parseGroupedInt", Num: s, Err: strconv.ErrSyntax}
+	}
+	for _, g := range groups[1:] {
+		if len(g) != 3 {
+			return 0, &strconv.NumError{Func: "parseGroupedInt", Num: s, Err: strconv.ErrSyntax}
+		}
+	}
+	return strconv.ParseInt(strings.Join(groups, ""), 10, 64)
+}
+
+// errAbsent is returned by parseNumericCell for cells marking a value as not
+// available, which aren't parse failures.
+var errAbsent = errors.New("Value not available")
+
+// absentMarkers are the cell contents TC4400 shows for unavailable values.
+var absentMarkers = map[string]bool{"n/a": true, "na": true, "-": true, "--": true, "---": true}
+
+// parseNumericCell parses an integer cell like parseGroupedInt, returning
+// errAbsent for markers like "N/A" or "---".
+func parseNumericCell(s string) (int64, error) {
+	if absentMarkers[strings.ToLower(strings.TrimSpace(s))] {
+		return 0, errAbsent
+	}
+	return parseGroupedInt(s)
+}
+
+// selectTable returns index if it is >= 0, otherwise the index of the first
+// table whose heading or else title row contains keyword, or fallback if
+// there is none. headings may be nil.
+func selectTable(tables [][][]string, headings []string, keyword string, index, fallback int) int {
+	if index >= 0 {
+		return index
+	}
+	keyword = strings.ToLower(keyword)
+	for i, heading := range headings {
+		if strings.Contains(strings.ToLower(heading), keyword) {
+			return i
+		}
+	}
+	for i, table := range tables {
+		if len(table) > 0 && len(table[0]) > 0 && strings.Contains(strings.ToLower(table[0][0]), keyword) {
+			return i
+		}
+	}
+	return fallback
+}
+
+// selectDownstreamTables returns index if it is >= 0, otherwise the indexes
+// of all downstream channel tables: those whose heading or else title row
+// contains "Downstream" and whose header has the 13 channel columns at
+// least. If there is none it returns the table selectTable does.
+func selectDownstreamTables(tables [][][]string, headings []string, index int) []int {
+	first := selectTable(tables, headings, "Downstream", index, 1)
+	if index >= 0 {
+		return []int{first}
+	}
+	isChannelTable := func(i int) bool {
+		return len(tables[i]) >= 2 && len(tables[i][1]) >= 13
+	}
+	indexes := []int{}
+	for i, heading := range headings {
+		if strings.Contains(strings.ToLower(heading), "downstream") && i < len(tables) && isChannelTable(i) {
+			indexes = append(indexes, i)
+		}
+	}
+	if len(indexes) == 0 {
+		for i, table := range tables {
+			if len(table) > 0 && len(table[0]) > 0 && strings.Contains(strings.ToLower(table[0][0]), "downstream") && isChannelTable(i) {
+				indexes = append(indexes, i)
+			}
+		}
+	}
+	if len(indexes) == 0 {
+		return []int{first}
+	}
+	return indexes
+}
+
+// transpose returns the columns of rows as rows. Short rows are padded with
+// empty cells.
+func transpose(rows [][]string) [][]string {
+	width := 0
+	for _, row := range rows {
+		if len(row) > width {
+			width = len(row)
+		}
+	}
+	columns := make([][]string, width)
+	for i := range columns {
+		columns[i] = make([]string, len(rows))
+		for j, row := range rows {
+			if i < len(row) {
+				columns[i][j] = row[i]
+			}
+		}
+	}
+	return columns
+}
+
+// isTransposed reports whether a channel table lists the channels as
+// columns, i.e. the header names like "Channel ID" and "Lock Status" are in
+// its first column instead of the row after the title.
+func isTransposed(table [][]string) bool {
+	if len(table) < 3 || selectColumn(table[1], "Lock Status") >= 0 {
+		return false
+	}
+	firstColumn := []string{}
+	for _, row := range table[1:] {
+		if len(row) > 0 {
+			firstColumn = append(firstColumn, row[0])
+		}
+	}
+	return selectColumn(firstColumn, "Channel ID") >= 0 && selectColumn(firstColumn, "Lock Status") >= 0
+}
+
+// selectColumn returns the index of the first header cell containing
+// keyword, ignoring case, or -1 if there is none.
+func selectColumn(header []string, keyword string) int {
+	keyword = strings.ToLower(keyword)
+	for i, cell := range header {
+		if strings.Contains(strings.ToLower(cell), keyword) {
+			return i
+		}
+	}
+	return -1
+}
+
+// splitValueUnit splits a cell like "591000000 Hz", "591000000Hz" or
+// " -2.5  dBmV " into its numeric prefix and the unit following it.
+func splitValueUnit(s string) (number string, unit string) {
+	s = strings.TrimSpace(s)
+	i := 0
+	if i < len(s) && (s[i] == '-' || s[i] == '+') {
+		i++
+	}
+	for i < len(s) && (s[i] >= '0' && s[i] <= '9' || s[i] == '.') {
+		i++
+	}
+	return s[:i], strings.TrimSpace(s[i:])
+}
+
+// normalizeDecimal rewrites a number like "-2,5 dBmV" or "1.234,5" that uses
+// separator as decimal separator to use a decimal point, dropping the
+// thousands separators. s is returned unchanged if separator is "." or empty.
+func normalizeDecimal(s, separator string) string {
+	if separator == "" || separator == "." {
+		return s
+	}
+	s = strings.NewReplacer(".", "", "'", "", "\u00a0", "", "\u202f", "").Replace(s)
+	return strings.Replace(s, separator, ".", -1)
+}
+
+// parsePercent parses a percentage like "12.5 %" or "12.5%" and returns it as
+// a fraction like 0.125.
+func parsePercent(s string) (float64, error) {
+	number, unit := splitValueUnit(s)
+	if unit != "%" || number == "" {
+		return 0, fmt.Errorf("Invalid percentage %q", s)
+	}
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, err
+	}
+	return value / 100, nil
+}
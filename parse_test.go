@@ -0,0 +1,452 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// parsePage parses the tables of a page, including nested ones.
+func parsePage(t *testing.T, page string) [][][]string {
+	t.Helper()
+	tables, err := parseTables(ioutil.NopCloser(strings.NewReader(page)), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tables
+}
+
+func TestTableToKV(t *testing.T) {
+	tables := parsePage(t, htmlPage(`<table>
+<tr><td>Model Name</td><td>TC4400</td></tr>
+<tr><td>Vendor Name</td><td><b>Technicolor</b></td></tr>
+<tr><td>System Uptime</td><td>1 day 02:03:04</td></tr>
+<tr><td>System Uptime</td><td>2 days 00:00:00</td></tr>
+<tr><td>Note</td></tr>
+<tr><td></td><td>Value without key</td></tr>
+<tr><td>Serial Number</td><td>ABC123</td><td>ignored</td></tr>
+</table>
+`))
+	expected := map[string]string{
+		"Model Name":    "TC4400",
+		"Vendor Name":   "Technicolor",
+		"System Uptime": "2 days 00:00:00",
+		"Serial Number": "ABC123",
+	}
+	if got := tableToKV(tables[0]); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+
+	if got := tableToKV(nil); len(got) != 0 {
+		t.Errorf("Expected no pairs for an empty table, got %v", got)
+	}
+}
+
+func TestParseGroupedInt(t *testing.T) {
+	for _, test := range []struct {
+		s        string
+		expected int64
+		valid    bool
+	}{
+		{"0", 0, true},
+		{"1234567", 1234567, true},
+		{"-42", -42, true},
+		{"1,234,567", 1234567, true},
+		{"1 234 567", 1234567, true},
+		{"1.234.567", 1234567, true},
+		{"1'234'567", 1234567, true},
+		{"1\u00a0234\u00a0567", 1234567, true},
+		{"-1,234", -1234, true},
+		{"999,999", 999999, true},
+		// Decimal commas and misplaced separators are rejected.
+		{"1,5", 0, false},
+		{"1,2345", 0, false},
+		{"1234,567", 0, false},
+		{",123", 0, false},
+		{"1,234.567", 0, false},
+		{"", 0, false},
+		{"n/a", 0, false},
+	} {
+		got, err := parseGroupedInt(test.s)
+		if (err == nil) != test.valid {
+			t.Errorf("%q: expected valid %v, got error %v", test.s, test.valid, err)
+		}
+		if test.valid && got != test.expected {
+			t.Errorf("%q: expected %d, got %d", test.s, test.expected, got)
+		}
+	}
+}
+
+func TestSelectTable(t *testing.T) {
+	tables := [][][]string{
+		{{"Startup Procedure"}, {"Procedure", "Status"}},
+		{{"Connection Summary"}, {"Item", "Value"}},
+		{{"Downstream Bonded Channels"}, downstreamHeader},
+		{{"Upstream Bonded Channels"}, upstreamHeader},
+	}
+	for _, test := range []struct {
+		headings []string
+		keyword  string
+		index    int
+		expected int
+	}{
+		{nil, "Downstream", -1, 2},
+		{nil, "upstream", -1, 3},
+		{nil, "Downstream", 1, 1},
+		{nil, "Missing", -1, -1},
+		// Headings take precedence over title rows.
+		{[]string{"", "", "Upstream", "Downstream"}, "Downstream", -1, 3},
+	} {
+		if got := selectTable(tables, test.headings, test.keyword, test.index, -1); got != test.expected {
+			t.Errorf("%q, index %d: expected table %d, got %d", test.keyword, test.index, test.expected, got)
+		}
+	}
+}
+
+func TestSelectDownstreamTables(t *testing.T) {
+	titled := [][][]string{
+		{{"Summary"}, {"Item", "Value"}},
+		{{"Downstream SC-QAM Channels"}, downstreamHeader},
+		{{"Upstream Bonded Channels"}, upstreamHeader},
+		{{"Downstream OFDM Channels"}, downstreamHeader},
+		// Not a channel table despite the title.
+		{{"Downstream Summary"}, {"Item", "Value"}},
+	}
+	untitled := [][][]string{
+		{{"Channels"}, downstreamHeader},
+		{{"Channels"}, upstreamHeader},
+		{{"Channels"}, downstreamHeader},
+	}
+	for _, test := range []struct {
+		name     string
+		tables   [][][]string
+		headings []string
+		index    int
+		expected []int
+	}{
+		{"title rows", titled, nil, -1, []int{1, 3}},
+		{"headings", untitled, []string{"Downstream SC-QAM", "Upstream", "Downstream OFDM"}, -1, []int{0, 2}},
+		{"configured index", titled, nil, 3, []int{3}},
+		// Without a match the second table is used like before.
+		{"none", untitled, nil, -1, []int{1}},
+	} {
+		if got := selectDownstreamTables(test.tables, test.headings, test.index); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%s: expected tables %v, got %v", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestTranspose(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		rows     [][]string
+		expected [][]string
+	}{
+		{"square", [][]string{{"a", "b"}, {"c", "d"}}, [][]string{{"a", "c"}, {"b", "d"}}},
+		{"wide", [][]string{{"Channel ID", "1", "2", "3"}, {"Lock Status", "Locked", "Locked", "Not Locked"}}, [][]string{{"Channel ID", "Lock Status"}, {"1", "Locked"}, {"2", "Locked"}, {"3", "Not Locked"}}},
+		{"short row", [][]string{{"a", "b", "c"}, {"d"}}, [][]string{{"a", "d"}, {"b", ""}, {"c", ""}}},
+		{"empty", nil, [][]string{}},
+	} {
+		if got := transpose(test.rows); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestIsTransposed(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		table    [][]string
+		expected bool
+	}{
+		{"rows", [][]string{{"Downstream"}, downstreamHeader, downstreamRow(1)}, false},
+		{"columns", [][]string{{"Downstream"}, {"Index", "1", "2"}, {"Channel ID", "1", "33"}, {"Lock Status", "Locked", "Locked"}}, true},
+		{"other labels", [][]string{{"Downstream"}, {"Channel", "1", "33"}, {"Lock", "Locked", "Locked"}}, false},
+		{"too short", [][]string{{"Downstream"}, {"Channel ID", "1"}}, false},
+	} {
+		if got := isTransposed(test.table); got != test.expected {
+			t.Errorf("%s: expected %t, got %t", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestRoleTables(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		page     string
+		expected [][][]string
+	}{
+		{"fixture", readTestdata(t, "aria-tables.html"), parsePage(t, readTestdata(t, "channel-types.html"))},
+		{"grid", htmlPage(`<div role="grid"><div role="row"><div role="gridcell">1</div></div></div>`), [][][]string{}},
+		{"cells", htmlPage(`<div role="table"><div role="row"><div role="rowheader">Model Name</div><div role="gridcell"><b>TC4400</b></div></div></div>`), [][][]string{{{"Model Name", "TC4400"}}}},
+		{"nested cells", htmlPage(`<div role="table"><div role="row"><div><span role="cell">a</span><span role="cell">b</span></div></div></div>`), [][][]string{{{"a", "b"}}}},
+		{"case", htmlPage(`<div role=" Table "><div role="Row"><div role="CELL">a</div></div></div>`), [][][]string{{{"a"}}}},
+		// Nested tables follow the outer one like for <table>.
+		{"nested table", htmlPage(`<div role="table"><div role="row"><div role="cell">a</div></div><div role="table"><div role="row"><div role="cell">b</div></div></div></div>`), [][][]string{{{"a"}}, {{"b"}}}},
+		{"mixed", htmlPage(`<table><tr><td>a</td></tr></table><div role="table"><div role="row"><div role="cell">b</div></div></div>`), [][][]string{{{"a"}}, {{"b"}}}},
+	} {
+		if got := parsePage(t, test.page); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestParseError(t *testing.T) {
+	cause := errors.New("cause")
+	for _, test := range []struct {
+		err      *ParseError
+		expected string
+	}{
+		{&ParseError{"cmswinfo.html", -1, -1, -1, parseReasonHTML, cause}, "Parsing cmswinfo.html failed (html): cause"},
+		{&ParseError{"cmconnectionstatus.html", 2, -1, -1, parseReasonTable, cause}, "Parsing cmconnectionstatus.html table 2 failed (table): cause"},
+		{&ParseError{"cmconnectionstatus.html", 1, 3, 8, parseReasonValue, cause}, "Parsing cmconnectionstatus.html table 1 row 3 column 8 failed (value): cause"},
+	} {
+		if got := test.err.Error(); got != test.expected {
+			t.Errorf("Expected %q, got %q", test.expected, got)
+		}
+		var wrapped error = fmt.Errorf("scrape: %w", test.err)
+		var parseErr *ParseError
+		if !errors.As(wrapped, &parseErr) || parseErr != test.err {
+			t.Errorf("Expected %v to be found in a wrapping error", test.err)
+		}
+		if !errors.Is(test.err, cause) {
+			t.Errorf("Expected %v to unwrap to its cause", test.err)
+		}
+	}
+}
+
+func TestParseTablesNested(t *testing.T) {
+	for _, test := range []struct {
+		skipNested bool
+		expected   []string
+	}{
+		// The nested table follows the one containing it.
+		{false, []string{"Startup Procedure", "Summary", "Downstream Channel Status", "Upstream Channel Status"}},
+		{true, []string{"Startup Procedure", "Downstream Channel Status", "Upstream Channel Status"}},
+	} {
+		tables, err := parseTables(ioutil.NopCloser(strings.NewReader(readTestdata(t, "nested-table.html"))), test.skipNested)
+		if err != nil {
+			t.Fatal(err)
+		}
+		titles := []string{}
+		for _, table := range tables {
+			titles = append(titles, table[0][0])
+		}
+		if !reflect.DeepEqual(titles, test.expected) {
+			t.Errorf("Skip nested %t: expected tables %q, got %q", test.skipNested, test.expected, titles)
+		}
+	}
+}
+
+func TestSplitValueUnit(t *testing.T) {
+	for _, test := range []struct {
+		s, number, unit string
+	}{
+		{"591000000 Hz", "591000000", "Hz"},
+		{"591000000Hz", "591000000", "Hz"},
+		{" 591000000  Hz ", "591000000", "Hz"},
+		{"-2.5 dBmV", "-2.5", "dBmV"},
+		{"-2.5dBmV", "-2.5", "dBmV"},
+		{"\t+3.1 dBmV\n", "+3.1", "dBmV"},
+		{"40.4 dB", "40.4", "dB"},
+		{" 256QAM ", "256", "QAM"},
+		{"8000000", "8000000", ""},
+		{"Hz", "", "Hz"},
+		{"", "", ""},
+	} {
+		if number, unit := splitValueUnit(test.s); number != test.number || unit != test.unit {
+			t.Errorf("%q: expected %q %q, got %q %q", test.s, test.number, test.unit, number, unit)
+		}
+	}
+}
+
+func TestParsePercent(t *testing.T) {
+	for _, test := range []struct {
+		s        string
+		expected float64
+		valid    bool
+	}{
+		{"12.5 %", 0.125, true},
+		{"12.5%", 0.125, true},
+		{" 12.5  % ", 0.125, true},
+		{"0%", 0, true},
+		{"100%", 1, true},
+		{"-5 %", -0.05, true},
+		{"12.5", 0, false},
+		{"%", 0, false},
+		{"12.5 dB", 0, false},
+		{"1.2.3%", 0, false},
+		{"", 0, false},
+	} {
+		got, err := parsePercent(test.s)
+		if (err == nil) != test.valid {
+			t.Errorf("%q: expected valid %t, got error %v", test.s, test.valid, err)
+		}
+		if test.valid && got != test.expected {
+			t.Errorf("%q: expected %g, got %g", test.s, test.expected, got)
+		}
+	}
+}
+
+func TestNormalizeDecimal(t *testing.T) {
+	for _, test := range []struct {
+		s, separator, expected string
+	}{
+		{"-2.5 dBmV", ".", "-2.5 dBmV"},
+		{"-2,5 dBmV", "", "-2,5 dBmV"},
+		{"-2,5 dBmV", ",", "-2.5 dBmV"},
+		{"40,4 dB", ",", "40.4 dB"},
+		{"1.234,5", ",", "1234.5"},
+		{"1'234,5", ",", "1234.5"},
+		{"1\u00a0234,5", ",", "1234.5"},
+		{"1\u202f234,5", ",", "1234.5"},
+		{"602000000 Hz", ",", "602000000 Hz"},
+	} {
+		if got := normalizeDecimal(test.s, test.separator); got != test.expected {
+			t.Errorf("%q with separator %q: expected %q, got %q", test.s, test.separator, test.expected, got)
+		}
+	}
+}
+
+// streamTables returns the tables of a page as parsed by parseTableStream.
+func streamTables(t *testing.T, page string) [][][]string {
+	t.Helper()
+	tables := [][][]string{}
+	err := parseTableStream(strings.NewReader(page), func(table, row int, cells []string) {
+		for len(tables) <= table {
+			tables = append(tables, [][]string{})
+		}
+		if row != len(tables[table]) {
+			t.Errorf("Table %d: expected row %d, got %d", table, len(tables[table]), row)
+		}
+		tables[table] = append(tables[table], cells)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tables
+}
+
+func TestParseTableStream(t *testing.T) {
+	pages := map[string]string{
+		"fixture.html": string(fixturePage),
+		"nested cell markup": htmlPage(`<table>
+<tr><td><b><i>nested</i></b> markup</td><td>a<br>b</td><td><span>1</span><span>2</span></td></tr>
+<tr><td>&lt;escaped&gt; &amp; &nbsp;</td><td></td></tr>
+</table>
+`),
+		"implied rows": htmlPage(`<table><thead><tr><th>A</th><th>B</th></thead><tbody><tr><td>1<td>2<tr><td>3<td>4</tbody></table>`),
+	}
+	files, err := filepath.Glob("testdata/*.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		// ARIA role tables are not streamed, see below.
+		if filepath.Base(file) == "aria-tables.html" {
+			continue
+		}
+		pages[filepath.Base(file)] = readTestdata(t, filepath.Base(file))
+	}
+
+	for name, page := range pages {
+		t.Run(name, func(t *testing.T) {
+			// Only top-level tables are streamed.
+			expected, err := parseTables(ioutil.NopCloser(strings.NewReader(page)), true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Empty tables yield no rows to stream.
+			for len(expected) > 0 && len(expected[len(expected)-1]) == 0 {
+				expected = expected[:len(expected)-1]
+			}
+			if got := streamTables(t, page); !reflect.DeepEqual(got, expected) {
+				t.Errorf("Expected the rows of parseTables\n%q\ngot\n%q", expected, got)
+			}
+		})
+	}
+
+	// ARIA role tables are not streamed.
+	page := htmlPage(`<div role="table"><div role="row"><span role="cell">role</span></div></div>`, htmlTable("Table", []string{"A"}, []string{"1"}))
+	if got := parsePage(t, page); len(got) != 2 {
+		t.Fatalf("Expected the role table and the table, got %q", got)
+	}
+	if got := streamTables(t, page); !reflect.DeepEqual(got, [][][]string{{{"Table"}, {"A"}, {"1"}}}) {
+		t.Errorf("Expected only the table, got %q", got)
+	}
+}
+
+func TestParseTableCellText(t *testing.T) {
+	tables := parsePage(t, htmlPage(`<table>
+<tr><td><b><i>nested</i></b> markup</td><td><span>1</span><span>2</span></td><td><div><p><b>deep</b></p></div><div>er</div></td></tr>
+</table>
+`))
+	expected := [][]string{{"nested markup", "12", "deeper"}}
+	if !reflect.DeepEqual(tables[0], expected) {
+		t.Errorf("Expected %q, got %q", expected, tables[0])
+	}
+}
+
+func TestParseHeadedTables(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		page     string
+		expected []string
+	}{
+		{"fixture", string(fixturePage), []string{"", "", ""}},
+		{"headings", readTestdata(t, "headings.html"), []string{"Startup Procedure", "", "Upstream Bonded Channels", "Downstream Bonded Channels"}},
+		// A heading applies to the next table only, the last one before
+		// it wins.
+		{"several headings", htmlPage("<h1>Page</h1><h2>First</h2>", htmlTable("A", []string{"A"}), htmlTable("B", []string{"B"}), "<strong>Second</strong>", htmlTable("C", []string{"C"})), []string{"First", "", "Second"}},
+		{"heading markup", htmlPage("<h2><span>Downstream</span> <i>Channels</i></h2>", htmlTable("A", []string{"A"})), []string{"Downstream Channels"}},
+	} {
+		tables, headings, err := parseHeadedTables(ioutil.NopCloser(strings.NewReader(test.page)), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tables) != len(headings) {
+			t.Errorf("%s: expected a heading per table, got %d tables and %d headings", test.name, len(tables), len(headings))
+		}
+		if !reflect.DeepEqual(headings, test.expected) {
+			t.Errorf("%s: expected headings %q, got %q", test.name, test.expected, headings)
+		}
+	}
+}
+
+func TestParseNumericCell(t *testing.T) {
+	for _, test := range []struct {
+		s        string
+		expected int64
+		// absent and valid tell markers of unavailable values from
+		// numbers and malformed cells.
+		absent, valid bool
+	}{
+		{"1,234", 1234, false, true},
+		{"0", 0, false, true},
+		{"N/A", 0, true, false},
+		{"n/a", 0, true, false},
+		{" NA ", 0, true, false},
+		{"-", 0, true, false},
+		{"--", 0, true, false},
+		{"---", 0, true, false},
+		{"", 0, false, false},
+		{"----", 0, false, false},
+		{"12 dB", 0, false, false},
+		{"unknown", 0, false, false},
+	} {
+		got, err := parseNumericCell(test.s)
+		if absent := err == errAbsent; absent != test.absent {
+			t.Errorf("%q: expected absent %t, got error %v", test.s, test.absent, err)
+		}
+		if (err == nil) != test.valid {
+			t.Errorf("%q: expected valid %t, got error %v", test.s, test.valid, err)
+		}
+		if test.valid && got != test.expected {
+			t.Errorf("%q: expected %d, got %d", test.s, test.expected, got)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+// Poll scrapes TC4400 once per Options.PollInterval until the process exits
+// and stores the result, which Collect then serves instead of scraping.
+func (e *Exporter) Poll() {
+	ticker := time.NewTicker(e.options.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		e.poll()
+		<-ticker.C
+	}
+}
+
+func (e *Exporter) poll() {
+	ch := make(chan prometheus.Metric)
+	cache := make(chan []prometheus.Metric)
+	go func() {
+		metrics := []prometheus.Metric{}
+		for m := range ch {
+			metrics = append(metrics, m)
+		}
+		cache <- metrics
+	}()
+
+	e.lockModem()
+	e.collectModem(ch)
+	e.collectSelf(ch)
+	e.mutex.Unlock()
+	close(ch)
+	metrics := <-cache
+
+	e.cacheMutex.Lock()
+	e.cache = metrics
+	e.cacheMutex.Unlock()
+	e.lastPoll.SetToCurrentTime()
+	log.Debugln("Polled", len(metrics), "metrics")
+}
+
+// collectCache sends the metrics of the last poll, including the
+// self-metrics as of its end. It doesn't wait for a running poll.
+func (e *Exporter) collectCache(ch chan<- prometheus.Metric) {
+	e.cacheMutex.RLock()
+	defer e.cacheMutex.RUnlock()
+
+	for _, m := range e.cache {
+		ch <- m
+	}
+	ch <- e.lastPoll
+}
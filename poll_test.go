@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestPoll(t *testing.T) {
+	m := newModem(t, map[string]string{"cmconnectionstatus.html": string(fixturePage)})
+	options := defaultOptions()
+	options.PollInterval = time.Hour
+	e := newTestExporter(t, m.URL, options)
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(e)
+
+	// Nothing is served before the first poll.
+	if got := withPrefix(gatherSeries(t, registry), "tc4400_downstream_locked"); len(got) != 0 {
+		t.Errorf("Expected no channels before polling, got %v", got)
+	}
+
+	before := float64(time.Now().UnixNano()) / 1e9
+	e.poll()
+	for i := 0; i < 3; i++ {
+		got := gatherSeries(t, registry)
+		if value := got[`tc4400_downstream_locked{channel="01"}`]; value != 1 {
+			t.Errorf("Expected the cached channel, got %v", withPrefix(got, "tc4400_downstream_locked"))
+		}
+		if value := got["tc4400_exporter_last_poll_timestamp_seconds"]; value < before {
+			t.Errorf("Expected the last poll after %f, got %f", before, value)
+		}
+		if value := got["tc4400_exporter_scrapes_total"]; value != 1 {
+			t.Errorf("Expected one scrape, got %g", value)
+		}
+	}
+	if requests := m.requestCount("cmconnectionstatus.html"); requests != 1 {
+		t.Errorf("Expected scrapes to be served from the cache, got %d requests", requests)
+	}
+
+	// A failing poll replaces the cache.
+	m.setPage("cmconnectionstatus.html", "")
+	m.Close()
+	e.poll()
+	got := gatherSeries(t, registry)
+	if channels := withPrefix(got, "tc4400_downstream_locked"); len(channels) != 0 {
+		t.Errorf("Expected no channels after a failed poll, got %v", channels)
+	}
+	if errors := withPrefix(got, "tc4400_exporter_last_error_info"); len(errors) != 1 {
+		t.Errorf("Expected the error of the failed poll, got %v", errors)
+	}
+	if value := got["tc4400_exporter_scrapes_total"]; value != 2 {
+		t.Errorf("Expected two scrapes, got %g", value)
+	}
+}
+
+func TestPollDoesNotBlockScrapes(t *testing.T) {
+	requested := make(chan bool, 1)
+	release := make(chan bool)
+	var slow int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/cmconnectionstatus.html" {
+			http.NotFound(w, r)
+			return
+		}
+		if atomic.LoadInt32(&slow) != 0 {
+			requested <- true
+			<-release
+		}
+		w.Write(fixturePage)
+	}))
+	defer server.Close()
+
+	options := defaultOptions()
+	options.PollInterval = time.Hour
+	options.CollectStatsifc = false
+	e := newTestExporter(t, server.URL, options)
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(e)
+	e.poll()
+
+	atomic.StoreInt32(&slow, 1)
+	polled := make(chan bool)
+	go func() {
+		e.poll()
+		close(polled)
+	}()
+	<-requested
+
+	gathered := make(chan map[string]float64)
+	go func() {
+		gathered <- gatherSeries(t, registry)
+	}()
+	select {
+	case got := <-gathered:
+		if value := got[`tc4400_downstream_locked{channel="01"}`]; value != 1 {
+			t.Errorf("Expected the cached channel during a poll, got %v", withPrefix(got, "tc4400_downstream_locked"))
+		}
+		if _, ok := got["tc4400_exporter_scrapes_total"]; !ok {
+			t.Error("Expected the self-metrics during a poll")
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Scrape waited for the running poll")
+	}
+	close(release)
+	<-polled
+}
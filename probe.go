@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// scrapeTimeoutHeader is the header Prometheus sends with the scrape timeout.
+const scrapeTimeoutHeader = "X-Prometheus-Scrape-Timeout-Seconds"
+
+// probeTimeout returns the timeout of a probe request: the smaller of the
+// timeout query parameter and the Prometheus scrape timeout header, both in
+// seconds, or fallback if neither is set.
+func probeTimeout(r *http.Request, fallback time.Duration) (time.Duration, error) {
+	timeout := time.Duration(0)
+	for _, value := range []string{r.URL.Query().Get("timeout"), r.Header.Get(scrapeTimeoutHeader)} {
+		if value == "" {
+			continue
+		}
+		seconds, err := strconv.ParseFloat(value, 64)
+		if err != nil || seconds <= 0 {
+			return 0, fmt.Errorf("Invalid probe timeout %q", value)
+		}
+		if t := time.Duration(seconds * float64(time.Second)); timeout == 0 || t < timeout {
+			timeout = t
+		}
+	}
+	if timeout == 0 {
+		return fallback, nil
+	}
+	return timeout, nil
+}
+
+// probeContext derives the context of a probe request, which is cancelled
+// when the probe timeout expires or the client goes away.
+func probeContext(r *http.Request, fallback time.Duration) (context.Context, context.CancelFunc, error) {
+	timeout, err := probeTimeout(r, fallback)
+	if err != nil {
+		return nil, nil, err
+	}
+	if timeout <= 0 {
+		ctx, cancel := context.WithCancel(r.Context())
+		return ctx, cancel, nil
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	return ctx, cancel, nil
+}
+
+// probeURI returns the URI of a probe target, which is a URI or a host like
+// "192.168.101.1". Targets without a scheme get that of the first of
+// scrapeURIs. Only targets on the host of one of scrapeURIs are trusted: if
+// they have no credentials of their own they get those of that URI.
+func probeURI(target string, scrapeURIs []string) (uri string, trusted bool, err error) {
+	scrapeURLs := []*url.URL{}
+	for _, scrapeURI := range scrapeURIs {
+		u, err := url.Parse(scrapeURI)
+		if err != nil {
+			return "", false, err
+		}
+		scrapeURLs = append(scrapeURLs, u)
+	}
+	if !strings.Contains(target, "://") {
+		scheme := "http"
+		if len(scrapeURLs) > 0 && scrapeURLs[0].Scheme != "" {
+			scheme = scrapeURLs[0].Scheme
+		}
+		target = scheme + "://" + target
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", false, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", false, fmt.Errorf("Invalid probe target %q", target)
+	}
+	for _, scrapeURL := range scrapeURLs {
+		if strings.EqualFold(u.Host, scrapeURL.Host) {
+			if u.User == nil {
+				u.User = scrapeURL.User
+			}
+			return u.String(), true, nil
+		}
+	}
+	return u.String(), false, nil
+}
+
+// probeTarget is the exporter of a probed TC4400. Its mutex serializes the
+// probes of the target, which set the context of the exporter.
+type probeTarget struct {
+	mutex    sync.Mutex
+	exporter *Exporter
+	registry *prometheus.Registry
+}
+
+// errProbeTargets is returned for a new target once maxTargets are probed.
+var errProbeTargets = errors.New("Too many probe targets")
+
+// prober keeps an exporter per probed TC4400, up to maxTargets.
+type prober struct {
+	scrapeURIs []string
+	timeout    time.Duration
+	options    Options
+	maxTargets int
+
+	mutex   sync.Mutex
+	targets map[string]*probeTarget
+}
+
+// target returns the exporter of a probe target URI, creating it unless
+// there are maxTargets already. Untrusted targets don't get the password
+// file.
+func (p *prober) target(uri string, trusted bool) (*probeTarget, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if target, ok := p.targets[uri]; ok {
+		return target, nil
+	}
+	if len(p.targets) >= p.maxTargets {
+		return nil, errProbeTargets
+	}
+	options := p.options
+	if !trusted {
+		options.PasswordFile = ""
+	}
+	exporter, err := NewExporter(uri, p.timeout, options)
+	if err != nil {
+		return nil, err
+	}
+	target := &probeTarget{exporter: exporter, registry: prometheus.NewRegistry()}
+	target.registry.MustRegister(exporter)
+	p.targets[uri] = target
+	return target, nil
+}
+
+// probeHandler scrapes the TC4400 given by the target parameter on each
+// request, like the blackbox_exporter, and answers with its metrics. Targets
+// on the host of one of scrapeURIs get its credentials, others are probed
+// without. At most maxTargets different targets are probed.
+func probeHandler(scrapeURIs []string, timeout time.Duration, options Options, maxTargets int) http.Handler {
+	// Every probe scrapes the modem itself.
+	options.PollInterval = 0
+	p := &prober{
+		scrapeURIs: scrapeURIs,
+		timeout:    timeout,
+		options:    options,
+		maxTargets: maxTargets,
+		targets:    map[string]*probeTarget{},
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target := r.URL.Query().Get("target")
+		if target == "" {
+			http.Error(w, "Target parameter is missing", http.StatusBadRequest)
+			return
+		}
+		uri, trusted, err := probeURI(target, p.scrapeURIs)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ctx, cancel, err := probeContext(r, 0)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer cancel()
+
+		t, err := p.target(uri, trusted)
+		if err == errProbeTargets {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+		t.exporter.ctx = ctx
+		defer func() { t.exporter.ctx = context.Background() }()
+		promhttp.HandlerFor(t.registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	})
+}
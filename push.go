@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/log"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	pushMinBackoff = time.Second
+	pushMaxBackoff = 30 * time.Second
+)
+
+type label struct {
+	name, value string
+}
+
+type timeSeries struct {
+	labels    []label
+	value     float64
+	timestamp int64
+}
+
+// Pusher periodically gathers metrics and sends them to a Prometheus
+// remote-write endpoint.
+type Pusher struct {
+	url      string
+	interval time.Duration
+	gatherer prometheus.Gatherer
+	client   *http.Client
+}
+
+func NewPusher(url string, interval time.Duration, gatherer prometheus.Gatherer) *Pusher {
+	return &Pusher{
+		url:      url,
+		interval: interval,
+		gatherer: gatherer,
+		client:   &http.Client{Timeout: interval},
+	}
+}
+
+// Run pushes once per interval until the process exits. Failed pushes are
+// retried with exponential backoff until the next interval is due.
+func (p *Pusher) Run() {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		deadline := time.Now().Add(p.interval)
+		backoff := pushMinBackoff
+		for {
+			err := p.push()
+			if err == nil {
+				break
+			}
+			log.Errorln("Remote write failed:", err)
+			if time.Now().Add(backoff).After(deadline) {
+				break
+			}
+			time.Sleep(backoff)
+			backoff *= 2
+			if backoff > pushMaxBackoff {
+				backoff = pushMaxBackoff
+			}
+		}
+		<-ticker.C
+	}
+}
+
+func (p *Pusher) push() error {
+	families, err := p.gatherer.Gather()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", p.url, bytes.NewReader(snappy.Encode(nil, encodeWriteRequest(toTimeSeries(families, time.Now())))))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if !(resp.StatusCode >= 200 && resp.StatusCode < 300) {
+		return fmt.Errorf("Pushing to %s failed: HTTP status %d", p.url, resp.StatusCode)
+	}
+	return nil
+}
+
+// toTimeSeries flattens gathered metric families into remote-write samples,
+// expanding histograms and summaries into their component series.
+func toTimeSeries(families []*dto.MetricFamily, now time.Time) []timeSeries {
+	timestamp := now.UnixNano() / int64(time.Millisecond)
+	series := []timeSeries{}
+
+	for _, mf := range families {
+		name := mf.GetName()
+		for _, m := range mf.GetMetric() {
+			add := func(suffix string, value float64, extra ...label) {
+				labels := []label{{"__name__", name + suffix}}
+				for _, lp := range m.GetLabel() {
+					labels = append(labels, label{lp.GetName(), lp.GetValue()})
+				}
+				labels = append(labels, extra...)
+				sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+				series = append(series, timeSeries{labels, value, timestamp})
+			}
+
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				add("", m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add("", m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add("", m.GetUntyped().GetValue())
+			case dto.MetricType_SUMMARY:
+				for _, q := range m.GetSummary().GetQuantile() {
+					add("", q.GetValue(), label{"quantile", strconv.FormatFloat(q.GetQuantile(), 'g', -1, 64)})
+				}
+				add("_sum", m.GetSummary().GetSampleSum())
+				add("_count", float64(m.GetSummary().GetSampleCount()))
+			case dto.MetricType_HISTOGRAM:
+				for _, b := range m.GetHistogram().GetBucket() {
+					add("_bucket", float64(b.GetCumulativeCount()), label{"le", strconv.FormatFloat(b.GetUpperBound(), 'g', -1, 64)})
+				}
+				add("_bucket", float64(m.GetHistogram().GetSampleCount()), label{"le", "+Inf"})
+				add("_sum", m.GetHistogram().GetSampleSum())
+				add("_count", float64(m.GetHistogram().GetSampleCount()))
+			}
+		}
+	}
+
+	return series
+}
+
+// encodeWriteRequest serializes series as a prometheus.WriteRequest protobuf
+// message.
+func encodeWriteRequest(series []timeSeries) []byte {
+	var b []byte
+	for _, ts := range series {
+		var tsb []byte
+		for _, l := range ts.labels {
+			var lb []byte
+			lb = protowire.AppendTag(lb, 1, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.name)
+			lb = protowire.AppendTag(lb, 2, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.value)
+			tsb = protowire.AppendTag(tsb, 1, protowire.BytesType)
+			tsb = protowire.AppendBytes(tsb, lb)
+		}
+
+		var sb []byte
+		sb = protowire.AppendTag(sb, 1, protowire.Fixed64Type)
+		sb = protowire.AppendFixed64(sb, math.Float64bits(ts.value))
+		sb = protowire.AppendTag(sb, 2, protowire.VarintType)
+		sb = protowire.AppendVarint(sb, uint64(ts.timestamp))
+		tsb = protowire.AppendTag(tsb, 2, protowire.BytesType)
+		tsb = protowire.AppendBytes(tsb, sb)
+
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, tsb)
+	}
+	return b
+}
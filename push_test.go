@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// decodeWriteRequest decodes the series of a prometheus.WriteRequest message
+// to lines like `name{label="value"} 1.5 @1000`, sorted.
+func decodeWriteRequest(t *testing.T, b []byte) []string {
+	t.Helper()
+	// fields calls fn with the number and content of each field of a
+	// message.
+	fields := func(b []byte, fn func(num protowire.Number, typ protowire.Type, b []byte) int) {
+		for len(b) > 0 {
+			num, typ, n := protowire.ConsumeTag(b)
+			if n < 0 {
+				t.Fatal(protowire.ParseError(n))
+			}
+			b = b[n:]
+			n = fn(num, typ, b)
+			if n < 0 {
+				t.Fatal(protowire.ParseError(n))
+			}
+			b = b[n:]
+		}
+	}
+
+	lines := []string{}
+	fields(b, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		ts, n := protowire.ConsumeBytes(b)
+		name, labels, sample := "", []string{}, ""
+		fields(ts, func(num protowire.Number, typ protowire.Type, b []byte) int {
+			message, n := protowire.ConsumeBytes(b)
+			switch num {
+			case 1:
+				var labelName, labelValue string
+				fields(message, func(num protowire.Number, typ protowire.Type, b []byte) int {
+					s, n := protowire.ConsumeString(b)
+					if num == 1 {
+						labelName = s
+					} else {
+						labelValue = s
+					}
+					return n
+				})
+				if labelName == "__name__" {
+					name = labelValue
+				} else {
+					labels = append(labels, fmt.Sprintf("%s=%q", labelName, labelValue))
+				}
+			case 2:
+				var value float64
+				var timestamp uint64
+				fields(message, func(num protowire.Number, typ protowire.Type, b []byte) int {
+					if num == 1 {
+						bits, n := protowire.ConsumeFixed64(b)
+						value = math.Float64frombits(bits)
+						return n
+					}
+					v, n := protowire.ConsumeVarint(b)
+					timestamp = v
+					return n
+				})
+				sample = fmt.Sprintf("%g @%d", value, timestamp)
+			}
+			return n
+		})
+		lines = append(lines, fmt.Sprintf("%s{%s} %s", name, strings.Join(labels, ","), sample))
+		return n
+	})
+	sort.Strings(lines)
+	return lines
+}
+
+func TestPush(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_gauge", Help: "Test gauge."}, []string{"channel"})
+	gauge.WithLabelValues("01").Set(1.5)
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_seconds", Help: "Test histogram.", Buckets: []float64{1}})
+	histogram.Observe(0.5)
+	histogram.Observe(2)
+	registry.MustRegister(gauge, histogram)
+
+	var body []byte
+	var headers http.Header
+	status := http.StatusNoContent
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header
+		compressed, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		if body, err = snappy.Decode(nil, compressed); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(status)
+	}))
+	defer receiver.Close()
+
+	pusher := NewPusher(receiver.URL, time.Minute, registry)
+	if err := pusher.push(); err != nil {
+		t.Fatal(err)
+	}
+	for header, expected := range map[string]string{
+		"Content-Encoding":                  "snappy",
+		"Content-Type":                      "application/x-protobuf",
+		"X-Prometheus-Remote-Write-Version": "0.1.0",
+	} {
+		if got := headers.Get(header); got != expected {
+			t.Errorf("Expected %s %q, got %q", header, expected, got)
+		}
+	}
+
+	got := decodeWriteRequest(t, body)
+	expected := []string{
+		`test_gauge{channel="01"} 1.5`,
+		`test_seconds_bucket{le="+Inf"} 2`,
+		`test_seconds_bucket{le="1"} 1`,
+		`test_seconds_count{} 2`,
+		`test_seconds_sum{} 2.5`,
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("Expected %d series, got %v", len(expected), got)
+	}
+	for i, line := range got {
+		// The timestamps are those of the push.
+		if !strings.HasPrefix(line, expected[i]+" @") {
+			t.Errorf("Expected %s, got %s", expected[i], line)
+		}
+	}
+
+	status = http.StatusBadRequest
+	if err := pusher.push(); err == nil {
+		t.Error("Expected an error for a rejected push")
+	}
+}
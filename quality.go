@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// QualityWeights are the weights of the locked, SNR, level and FEC components
+// of the signal quality score.
+type QualityWeights [4]float64
+
+// defaultQualityWeights weigh all components equally.
+var defaultQualityWeights = QualityWeights{1, 1, 1, 1}
+
+// ParseQualityWeights parses a comma separated list of the four
+// non-negative quality score weights.
+func ParseQualityWeights(list string) (QualityWeights, error) {
+	weights := QualityWeights{}
+	fields := strings.Split(list, ",")
+	if len(fields) != len(weights) {
+		return weights, fmt.Errorf("Expected %d weights: %s", len(weights), list)
+	}
+	sum := 0.0
+	for i, field := range fields {
+		weight, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil || weight < 0 {
+			return weights, fmt.Errorf("Invalid weight %q", field)
+		}
+		weights[i] = weight
+		sum += weight
+	}
+	if sum == 0 {
+		return weights, fmt.Errorf("All weights are 0: %s", list)
+	}
+	return weights, nil
+}
+
+// signalQuality accumulates the channel values the signal quality score is
+// computed from during a scrape.
+type signalQuality struct {
+	channels, locked     int
+	snrMargins           []float64
+	levels, levelInRange int
+	codewords, failed    float64
+}
+
+// addDownstream adds a downstream channel row. snr is the cell holding the
+// measured SNR.
+func (q *signalQuality) addDownstream(e *Exporter, row []string, snr string) {
+	q.channels++
+	if row[2] != "Locked" {
+		return
+	}
+	q.locked++
+
+	if value, ok := parseSNR(e.decimal(snr)); ok {
+		q.snrMargins = append(q.snrMargins, value-e.options.HealthMinSNR)
+	}
+	if level, ok := parseLevel(e.decimal(row[8])); ok {
+		q.levels++
+		if level >= e.options.HealthMinReceiveLevel && level <= e.options.HealthMaxReceiveLevel {
+			q.levelInRange++
+		}
+	}
+	for i := 10; i <= 12; i++ {
+		value, err := parseNumericCell(row[i])
+		if err != nil {
+			return
+		}
+		q.codewords += float64(value)
+		if i == 12 {
+			q.failed += float64(value)
+		}
+	}
+}
+
+// addUpstream adds an upstream channel row.
+func (q *signalQuality) addUpstream(row []string) {
+	q.channels++
+	if row[2] == "Locked" {
+		q.locked++
+	}
+}
+
+// score returns the weighted mean of the components as 0 to 100, or false
+// if no channels were added. Components without values are left out.
+func (q *signalQuality) score(weights QualityWeights) (float64, bool) {
+	if q.channels == 0 {
+		return 0, false
+	}
+
+	var components [4]float64
+	var valid [4]bool
+	components[0], valid[0] = float64(q.locked)/float64(q.channels), true
+	for _, margin := range q.snrMargins {
+		components[1] += math.Max(0, math.Min(1, margin/10)) / float64(len(q.snrMargins))
+		valid[1] = true
+	}
+	if q.levels > 0 {
+		components[2], valid[2] = float64(q.levelInRange)/float64(q.levels), true
+	}
+	if q.codewords > 0 {
+		components[3], valid[3] = 1-q.failed/q.codewords, true
+	}
+
+	score, sum := 0.0, 0.0
+	for i, weight := range weights {
+		if valid[i] {
+			score += weight * components[i]
+			sum += weight
+		}
+	}
+	if sum == 0 {
+		return 0, false
+	}
+	return 100 * score / sum, true
+}
+
+// parseSNR parses an SNR like "38.5 dB".
+func parseSNR(s string) (float64, bool) {
+	number, unit := splitValueUnit(s)
+	if unit != "dB" {
+		return 0, false
+	}
+	value, err := strconv.ParseFloat(number, 64)
+	return value, err == nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// downstreamState holds per-channel values remembered between scrapes. It is
+// only accessed from scrape() and therefore guarded by Exporter.mutex.
+type downstreamState struct {
+	// codewords holds the unerrored, corrected and uncorrectable codeword
+	// counters (columns 10 to 12) of the previous scrape.
+	codewords           [3]float64
+	uncorrectableEvents float64
+	lastReset           time.Time
+
+	// locked is the lock status of the previous scrape, lockFlaps the
+	// number of times it changed.
+	locked    bool
+	lockFlaps float64
+
+	// frequency is the center frequency in Hz of the previous scrape it
+	// could be parsed in, frequencyChanges the number of times it changed.
+	frequency        float64
+	frequencyChanges float64
+
+	// profile is the profile ID or QAM order of an OFDM channel of the
+	// previous scrape, 0 if unknown, profileDowngrades the number of times
+	// it decreased.
+	profile           float64
+	profileDowngrades float64
+
+	// corrected and uncorrectable accumulate the codeword counter increases
+	// since the channel was first seen, counting across counter resets.
+	corrected     float64
+	uncorrectable float64
+}
+
+// trackDownstream updates the state of a downstream channel from its row and
+// emits the metrics derived from it.
+func (e *Exporter) trackDownstream(ch chan<- prometheus.Metric, channelLabel string, row []string) {
+	state, seen := e.downstreamStates[channelLabel]
+	if !seen {
+		state = &downstreamState{}
+		e.downstreamStates[channelLabel] = state
+	}
+
+	var codewords [3]float64
+	valid := true
+	for i := range codewords {
+		valueInt, err := parseGroupedInt(row[10+i])
+		if err != nil {
+			valid = false
+			break
+		}
+		codewords[i] = float64(valueInt)
+	}
+
+	locked := row[2] == "Locked"
+	if seen && locked != state.locked {
+		state.lockFlaps++
+	}
+	state.locked = locked
+
+	if frequency, ok := parseFrequency(e.decimal(row[5])); ok {
+		if state.frequency != 0 && frequency != state.frequency {
+			state.frequencyChanges++
+		}
+		state.frequency = frequency
+	}
+
+	ofdm := strings.Contains(row[3], "OFDM")
+	if profile, ok := parseProfile(row[9]); ok && ofdm {
+		if state.profile != 0 && profile < state.profile {
+			state.profileDowngrades++
+		}
+		state.profile = profile
+	} else if !ofdm {
+		state.profile = 0
+	}
+
+	if valid {
+		if seen {
+			for i := range codewords {
+				if codewords[i] < state.codewords[i] {
+					state.lastReset = time.Now()
+					break
+				}
+			}
+			if codewords[2] > state.codewords[2] {
+				state.uncorrectableEvents++
+			}
+			state.corrected += counterIncrease(state.codewords[1], codewords[1])
+			state.uncorrectable += counterIncrease(state.codewords[2], codewords[2])
+		}
+		state.codewords = codewords
+	}
+
+	ch <- prometheus.MustNewConstMetric(e.descs.downstreamUncorrectableEvents, prometheus.CounterValue, state.uncorrectableEvents, channelLabel)
+	ch <- prometheus.MustNewConstMetric(e.descs.downstreamLockFlaps, prometheus.CounterValue, state.lockFlaps, channelLabel)
+	ch <- prometheus.MustNewConstMetric(e.descs.downstreamFrequencyChanges, prometheus.CounterValue, state.frequencyChanges, channelLabel)
+	if ofdm {
+		ch <- prometheus.MustNewConstMetric(e.descs.downstreamProfileDowngrades, prometheus.CounterValue, state.profileDowngrades, channelLabel)
+	}
+	if !state.lastReset.IsZero() {
+		ch <- prometheus.MustNewConstMetric(e.descs.downstreamCounterReset, prometheus.GaugeValue, float64(state.lastReset.UnixNano())/1e9, channelLabel)
+	}
+	if total := state.corrected + state.uncorrectable; total > 0 {
+		ch <- prometheus.MustNewConstMetric(e.descs.downstreamFECFailureRatio, prometheus.GaugeValue, state.uncorrectable/total, channelLabel)
+	}
+}
+
+// parseProfile returns the profile ID of a cell like "2" or the order of a
+// modulation like "4096QAM", or false if it is neither.
+func parseProfile(s string) (float64, bool) {
+	if id, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64); err == nil {
+		return float64(id), true
+	}
+	order, err := parseQAM(s)
+	return order, err == nil && order > 0
+}
+
+// counterIncrease returns how much a counter increased between two scrapes.
+// After a reset the current value is the increase.
+func counterIncrease(previous, current float64) float64 {
+	if current < previous {
+		return current
+	}
+	return current - previous
+}
+
+// pruneDownstream forgets channels that were not part of the last scrape so
+// a returning channel starts over rather than comparing against stale values.
+func (e *Exporter) pruneDownstream(channels map[string]bool) {
+	for channel := range e.downstreamStates {
+		if !channels[channel] {
+			delete(e.downstreamStates, channel)
+		}
+	}
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// scrapeSequence scrapes one exporter once per cmconnectionstatus.html page
+// with the default options changed by tweak, if not nil, and returns the
+// samples of each scrape.
+func scrapeSequence(t *testing.T, tweak func(*Options), pages ...string) []map[string]float64 {
+	t.Helper()
+	m := newModem(t, nil)
+	options := defaultOptions()
+	if tweak != nil {
+		tweak(&options)
+	}
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(newTestExporter(t, m.URL, options))
+	scrapes := []map[string]float64{}
+	for _, page := range pages {
+		m.setPage("cmconnectionstatus.html", page)
+		scrapes = append(scrapes, gatherSeries(t, registry))
+	}
+	return scrapes
+}
+
+// channelPages returns a cmconnectionstatus.html per step, listing the
+// channels returned by rows for that step.
+func channelPages(steps int, rows func(step int) [][]string) []string {
+	pages := []string{}
+	for step := 0; step < steps; step++ {
+		pages = append(pages, connectionStatusPage(rows(step), [][]string{upstreamRow}))
+	}
+	return pages
+}
+
+func TestUncorrectableEvents(t *testing.T) {
+	for _, test := range []struct {
+		name          string
+		uncorrectable []string
+		expected      []float64
+	}{
+		{"stable", []string{"3", "3", "3"}, []float64{0, 0, 0}},
+		{"increasing", []string{"3", "5", "9"}, []float64{0, 1, 2}},
+		{"increasing and stable", []string{"3", "5", "5", "6"}, []float64{0, 1, 1, 2}},
+		{"reset", []string{"9", "0", "2"}, []float64{0, 0, 1}},
+		{"unparsable", []string{"3", "n/a", "5"}, []float64{0, 0, 1}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			pages := channelPages(len(test.uncorrectable), func(step int) [][]string {
+				row := downstreamRow(1)
+				row[12] = test.uncorrectable[step]
+				return [][]string{row, downstreamRow(2)}
+			})
+			for i, scrape := range scrapeSequence(t, nil, pages...) {
+				if got := scrape[`tc4400_downstream_uncorrectable_events_total{channel="01"}`]; got != test.expected[i] {
+					t.Errorf("Scrape %d: expected %g events, got %g", i, test.expected[i], got)
+				}
+				if got := scrape[`tc4400_downstream_uncorrectable_events_total{channel="02"}`]; got != 0 {
+					t.Errorf("Scrape %d: expected no events of the stable channel, got %g", i, got)
+				}
+			}
+		})
+	}
+
+	// A channel that disappears starts over when it returns.
+	pages := channelPages(4, func(step int) [][]string {
+		row := downstreamRow(1)
+		row[12] = fmt.Sprint(10 * step)
+		if step == 2 {
+			return [][]string{downstreamRow(2)}
+		}
+		return [][]string{row, downstreamRow(2)}
+	})
+	expected := []float64{0, 1, -1, 0}
+	for i, scrape := range scrapeSequence(t, nil, pages...) {
+		got, ok := scrape[`tc4400_downstream_uncorrectable_events_total{channel="01"}`]
+		if expected[i] < 0 {
+			if ok {
+				t.Errorf("Scrape %d: expected no events of a missing channel, got %g", i, got)
+			}
+			continue
+		}
+		if got != expected[i] {
+			t.Errorf("Scrape %d: expected %g events, got %g", i, expected[i], got)
+		}
+	}
+}
+
+func TestCounterReset(t *testing.T) {
+	m := newModem(t, nil)
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(newTestExporter(t, m.URL, defaultOptions()))
+	const key = `tc4400_downstream_counter_reset_timestamp_seconds{channel="01"}`
+
+	var last float64
+	for i, test := range []struct {
+		corrected string
+		reset     bool
+	}{
+		{"100", false},
+		{"200", false},
+		{"50", true},
+		{"60", false},
+		{"60", false},
+		{"10", true},
+	} {
+		row := downstreamRow(1)
+		row[11] = test.corrected
+		m.setPage("cmconnectionstatus.html", connectionStatusPage([][]string{row}, [][]string{upstreamRow}))
+		before := float64(time.Now().UnixNano()) / 1e9
+		got, ok := gatherSeries(t, registry)[key]
+		after := float64(time.Now().UnixNano()) / 1e9
+
+		switch {
+		case last == 0 && !test.reset:
+			if ok {
+				t.Errorf("Scrape %d: expected no reset timestamp before a reset, got %f", i, got)
+			}
+		case test.reset:
+			if got < before || got > after {
+				t.Errorf("Scrape %d: expected a reset timestamp between %f and %f, got %f", i, before, after, got)
+			}
+		default:
+			if got != last {
+				t.Errorf("Scrape %d: expected the reset timestamp %f to be kept, got %f", i, last, got)
+			}
+		}
+		if ok {
+			last = got
+		}
+	}
+}
+
+func TestFECFailureRatio(t *testing.T) {
+	for _, test := range []struct {
+		name                     string
+		corrected, uncorrectable []string
+		// expected is the ratio per scrape, -1 if none is exported.
+		expected []float64
+	}{
+		{"no errors", []string{"0", "0", "0"}, []string{"0", "0", "0"}, []float64{-1, -1, -1}},
+		{"stable", []string{"10", "10", "10"}, []string{"3", "3", "3"}, []float64{-1, -1, -1}},
+		{"increasing uncorrectable", []string{"10", "10", "10", "10"}, []string{"3", "4", "6", "9"}, []float64{-1, 1, 1, 1}},
+		{"increasing both", []string{"10", "13", "16", "19"}, []string{"3", "4", "5", "9"}, []float64{-1, 0.25, 0.25, 0.4}},
+		{"corrected only", []string{"10", "20"}, []string{"3", "3"}, []float64{-1, 0}},
+		{"reset", []string{"10", "20", "4"}, []string{"3", "3", "5"}, []float64{-1, 0, 0.125}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			pages := channelPages(len(test.expected), func(step int) [][]string {
+				row := downstreamRow(1)
+				row[11], row[12] = test.corrected[step], test.uncorrectable[step]
+				return [][]string{row}
+			})
+			for i, scrape := range scrapeSequence(t, nil, pages...) {
+				got, ok := scrape[`tc4400_downstream_fec_failure_ratio{channel="01"}`]
+				if test.expected[i] < 0 {
+					if ok {
+						t.Errorf("Scrape %d: expected no ratio without codeword increases, got %g", i, got)
+					}
+					continue
+				}
+				if !ok || got != test.expected[i] {
+					t.Errorf("Scrape %d: expected a ratio of %g, got %g (present: %t)", i, test.expected[i], got, ok)
+				}
+			}
+		})
+	}
+
+	// A channel that disappears starts over when it returns.
+	pages := channelPages(4, func(step int) [][]string {
+		row := downstreamRow(1)
+		row[11], row[12] = fmt.Sprint(10*step), fmt.Sprint(10*step)
+		if step == 2 {
+			return [][]string{downstreamRow(2)}
+		}
+		return [][]string{row, downstreamRow(2)}
+	})
+	scrapes := scrapeSequence(t, nil, pages...)
+	if got := scrapes[1][`tc4400_downstream_fec_failure_ratio{channel="01"}`]; got != 0.5 {
+		t.Errorf("Expected a ratio of 0.5 before the channel disappeared, got %g", got)
+	}
+	if got, ok := scrapes[3][`tc4400_downstream_fec_failure_ratio{channel="01"}`]; ok {
+		t.Errorf("Expected no ratio for a returning channel, got %g", got)
+	}
+}
+
+func TestLockFlaps(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		locks    []string
+		expected []float64
+	}{
+		{"stable", []string{"Locked", "Locked", "Locked"}, []float64{0, 0, 0}},
+		{"down", []string{"Not Locked", "Not Locked"}, []float64{0, 0}},
+		{"lost", []string{"Locked", "Not Locked", "Not Locked"}, []float64{0, 1, 1}},
+		{"flapping", []string{"Locked", "Not Locked", "Locked", "Not Locked", "Locked"}, []float64{0, 1, 2, 3, 4}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			pages := channelPages(len(test.locks), func(step int) [][]string {
+				row := downstreamRow(1)
+				row[2] = test.locks[step]
+				return [][]string{row, downstreamRow(2)}
+			})
+			for i, scrape := range scrapeSequence(t, nil, pages...) {
+				if got := scrape[`tc4400_downstream_lock_flaps_total{channel="01"}`]; got != test.expected[i] {
+					t.Errorf("Scrape %d: expected %g flaps, got %g", i, test.expected[i], got)
+				}
+				if got := scrape[`tc4400_downstream_lock_flaps_total{channel="02"}`]; got != 0 {
+					t.Errorf("Scrape %d: expected no flaps of the stable channel, got %g", i, got)
+				}
+			}
+		})
+	}
+
+	// A channel that disappears starts over when it returns.
+	locks := []string{"Locked", "Not Locked", "", "Not Locked", "Locked"}
+	pages := channelPages(len(locks), func(step int) [][]string {
+		if locks[step] == "" {
+			return [][]string{downstreamRow(2)}
+		}
+		row := downstreamRow(1)
+		row[2] = locks[step]
+		return [][]string{row, downstreamRow(2)}
+	})
+	expected := []float64{0, 1, -1, 0, 1}
+	for i, scrape := range scrapeSequence(t, nil, pages...) {
+		got, ok := scrape[`tc4400_downstream_lock_flaps_total{channel="01"}`]
+		if expected[i] < 0 {
+			if ok {
+				t.Errorf("Scrape %d: expected no flaps of a missing channel, got %g", i, got)
+			}
+			continue
+		}
+		if got != expected[i] {
+			t.Errorf("Scrape %d: expected %g flaps, got %g", i, expected[i], got)
+		}
+	}
+}
+
+func TestFrequencyChanges(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		frequencies []string
+		expected    []float64
+	}{
+		{"stable", []string{"602000000 Hz", "602000000 Hz", "602000000 Hz"}, []float64{0, 0, 0}},
+		{"changed", []string{"602000000 Hz", "602000000 Hz", "610000000 Hz", "610000000 Hz"}, []float64{0, 0, 1, 1}},
+		{"changed back", []string{"602000000 Hz", "610000000 Hz", "602000000 Hz"}, []float64{0, 1, 2}},
+		{"unit", []string{"602000000 Hz", "602000 kHz", "602500 kHz"}, []float64{0, 0, 1}},
+		// Unparsable frequencies are skipped, not counted as change.
+		{"unparsable", []string{"602000000 Hz", "n/a", "602000000 Hz", "n/a", "610000000 Hz"}, []float64{0, 0, 0, 0, 1}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			pages := channelPages(len(test.frequencies), func(step int) [][]string {
+				row := downstreamRow(1)
+				row[5] = test.frequencies[step]
+				return [][]string{row, downstreamRow(2)}
+			})
+			for i, scrape := range scrapeSequence(t, nil, pages...) {
+				if got := scrape[`tc4400_downstream_frequency_changes_total{channel="01"}`]; got != test.expected[i] {
+					t.Errorf("Scrape %d: expected %g changes, got %g", i, test.expected[i], got)
+				}
+				if got := scrape[`tc4400_downstream_frequency_changes_total{channel="02"}`]; got != 0 {
+					t.Errorf("Scrape %d: expected no changes of the stable channel, got %g", i, got)
+				}
+			}
+		})
+	}
+
+	// A channel that disappears starts over when it returns.
+	frequencies := []string{"602000000 Hz", "610000000 Hz", "", "618000000 Hz", "618000000 Hz"}
+	pages := channelPages(len(frequencies), func(step int) [][]string {
+		if frequencies[step] == "" {
+			return [][]string{downstreamRow(2)}
+		}
+		row := downstreamRow(1)
+		row[5] = frequencies[step]
+		return [][]string{row, downstreamRow(2)}
+	})
+	expected := []float64{0, 1, -1, 0, 0}
+	for i, scrape := range scrapeSequence(t, nil, pages...) {
+		got, ok := scrape[`tc4400_downstream_frequency_changes_total{channel="01"}`]
+		if expected[i] < 0 {
+			if ok {
+				t.Errorf("Scrape %d: expected no changes of a missing channel, got %g", i, got)
+			}
+			continue
+		}
+		if got != expected[i] {
+			t.Errorf("Scrape %d: expected %g changes, got %g", i, expected[i], got)
+		}
+	}
+}
+
+func TestParseProfile(t *testing.T) {
+	for _, test := range []struct {
+		s        string
+		expected float64
+		ok       bool
+	}{
+		{"2", 2, true},
+		{" 0 ", 0, true},
+		{"4096QAM", 4096, true},
+		{"QAM256", 256, true},
+		{"Unknown", 0, false},
+		{"", 0, false},
+	} {
+		if got, ok := parseProfile(test.s); got != test.expected || ok != test.ok {
+			t.Errorf("%q: expected %g (%t), got %g (%t)", test.s, test.expected, test.ok, got, ok)
+		}
+	}
+}
+
+func TestProfileDowngrades(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		types    []string
+		profiles []string
+		// expected are the downgrades per scrape, -1 where no metric is
+		// expected.
+		expected []float64
+	}{
+		{"stable", []string{"OFDM", "OFDM", "OFDM"}, []string{"3", "3", "3"}, []float64{0, 0, 0}},
+		{"downgrade", []string{"OFDM", "OFDM", "OFDM", "OFDM"}, []string{"3", "1", "1", "0"}, []float64{0, 1, 1, 2}},
+		{"upgrade", []string{"OFDM", "OFDM", "OFDM"}, []string{"1", "3", "2"}, []float64{0, 0, 1}},
+		{"modulation", []string{"OFDM", "OFDM", "OFDM"}, []string{"4096QAM", "1024QAM", "4096QAM"}, []float64{0, 1, 1}},
+		// Unparsable profiles are skipped.
+		{"unparsable", []string{"OFDM", "OFDM", "OFDM"}, []string{"3", "Unknown", "2"}, []float64{0, 0, 1}},
+		// A channel turning SC-QAM has no profile, so the next one isn't
+		// compared to the one before.
+		{"channel type change", []string{"OFDM", "SC-QAM", "OFDM", "OFDM"}, []string{"3", "256QAM", "2", "1"}, []float64{0, -1, 0, 1}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			pages := channelPages(len(test.profiles), func(step int) [][]string {
+				row := downstreamRow(1)
+				row[3] = test.types[step]
+				row[9] = test.profiles[step]
+				return [][]string{row, downstreamRow(2)}
+			})
+			for i, scrape := range scrapeSequence(t, nil, pages...) {
+				got, ok := scrape[`tc4400_downstream_profile_downgrades_total{channel="01"}`]
+				if test.expected[i] < 0 {
+					if ok {
+						t.Errorf("Scrape %d: expected no downgrades of an SC-QAM channel, got %g", i, got)
+					}
+					continue
+				}
+				if got != test.expected[i] {
+					t.Errorf("Scrape %d: expected %g downgrades, got %g", i, test.expected[i], got)
+				}
+				if _, ok := scrape[`tc4400_downstream_profile_downgrades_total{channel="02"}`]; ok {
+					t.Errorf("Scrape %d: expected no downgrades of an SC-QAM channel", i)
+				}
+			}
+		})
+	}
+}
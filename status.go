@@ -0,0 +1,347 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+// firmwareKeys are the keys of the firmware version in cmswinfo.html.
+var firmwareKeys = []string{"Software Version", "Firmware Version", "Firmware Name"}
+
+// fetchStatus returns the key/value pairs of all tables of the device status
+// page, or nil if it can't be fetched or parsed - cmswinfo.html
+func (e *Exporter) fetchStatus() map[string]string {
+	body, err := e.fetch("cmswinfo.html")
+	if err != nil {
+		return nil
+	}
+	tables, _, err := e.parseTables("cmswinfo.html", body)
+	body.Close()
+	if err != nil {
+		e.parseFailed(&ParseError{"cmswinfo.html", -1, -1, -1, parseReasonHTML, err})
+		return nil
+	}
+
+	kv := map[string]string{}
+	for _, table := range tables {
+		for k, v := range tableToKV(table) {
+			kv[k] = v
+		}
+	}
+	return kv
+}
+
+// deviceInfoLabels are the labels of the device info metric and the keys of
+// their values in cmswinfo.html.
+var deviceInfoLabels = []struct {
+	name string
+	keys []string
+}{
+	{"vendor", []string{"Vendor Name", "Vendor", "Manufacturer"}},
+	{"model", []string{"Model Name", "Model Number", "Model"}},
+	{"hw_version", []string{"Hardware Version"}},
+	{"boot_version", []string{"Boot Version", "Bootloader Version"}},
+	{"serial", []string{"Cable Modem Serial Number", "Serial Number"}},
+	{"mac", []string{"Cable Modem MAC Address", "MAC Address"}},
+}
+
+func deviceInfoLabelNames() []string {
+	names := []string{}
+	for _, l := range deviceInfoLabels {
+		names = append(names, l.name)
+	}
+	return names
+}
+
+// emitStatus emits the metrics found in the device status page.
+func (e *Exporter) emitStatus(ch chan<- prometheus.Metric, kv map[string]string) {
+	for family, keys := range map[string][]string{
+		"ipv4": {"Cable Modem IPv4 Address", "Cable Modem IP Address", "IPv4 Address"},
+		"ipv6": {"Cable Modem IPv6 Address", "IPv6 Address"},
+	} {
+		if value, ok := lookupKV(kv, keys...); ok {
+			if ip := parseIP(value); ip != "" {
+				ch <- prometheus.MustNewConstMetric(e.descs.cmIPInfo, prometheus.GaugeValue, 1, ip, family)
+			}
+		}
+	}
+
+	if value, ok := lookupKV(kv, "TFTP Server", "TFTP Server Address", "Boot File TFTP Server", "Config File Server"); ok && !absentMarkers[strings.ToLower(strings.TrimSpace(value))] {
+		if server, err := parseServer(value); err != nil {
+			e.parseFailed(&ParseError{"cmswinfo.html", -1, -1, -1, parseReasonValue, err})
+		} else if server != "" {
+			ch <- prometheus.MustNewConstMetric(e.descs.cmTFTPServerInfo, prometheus.GaugeValue, 1, server)
+		}
+	}
+
+	if value, ok := lookupKV(kv, "CPE Count", "Number of CPEs", "Number of CPE", "Connected CPEs", "Attached Devices"); ok && value != "" {
+		count, err := parseNumericCell(value)
+		if err == nil && count < 0 {
+			err = fmt.Errorf("Negative CPE count %d", count)
+		}
+		if err != nil && err != errAbsent {
+			e.parseFailed(&ParseError{"cmswinfo.html", -1, -1, -1, parseReasonValue, err})
+		} else if err == nil {
+			ch <- prometheus.MustNewConstMetric(e.descs.cmCPECount, prometheus.GaugeValue, float64(count))
+		}
+	}
+
+	if value, ok := lookupKV(kv, "Temperature", "Device Temperature", "System Temperature"); ok && value != "" {
+		celsius, err := parseTemperature(value)
+		if err != nil {
+			e.parseFailed(&ParseError{"cmswinfo.html", -1, -1, -1, parseReasonValue, err})
+		} else {
+			ch <- prometheus.MustNewConstMetric(e.descs.deviceTemperature, prometheus.GaugeValue, e.round(celsius))
+		}
+	}
+
+	if value, ok := lookupKV(kv, "Firmware Update Status", "Firmware Update", "Software Update Status", "Software Update", "Update Available"); ok && value != "" {
+		pending, err := parseUpdatePending(value)
+		if err != nil {
+			e.parseFailed(&ParseError{"cmswinfo.html", -1, -1, -1, parseReasonValue, err})
+		} else {
+			ch <- prometheus.MustNewConstMetric(e.descs.firmwareUpdatePending, prometheus.GaugeValue, pending)
+		}
+	}
+
+	if value, ok := lookupKV(kv, "Reboot Count", "Reset Count", "Restart Count", "System Resets"); ok && value != "" {
+		resets, err := parseGroupedInt(value)
+		if err != nil {
+			e.parseFailed(&ParseError{"cmswinfo.html", -1, -1, -1, parseReasonValue, err})
+		} else {
+			if e.resets >= 0 && resets < e.resets {
+				log.Warnf("Reset count decreased from %d to %d", e.resets, resets)
+			}
+			e.resets = resets
+			ch <- prometheus.MustNewConstMetric(e.descs.systemResets, prometheus.CounterValue, float64(resets))
+		}
+	}
+
+	if value, ok := lookupKV(kv, "Downstream Bonding Group Size", "Bonding Group Size", "Downstream Channels Supported"); ok && value != "" {
+		size, err := parseGroupedInt(value)
+		if err != nil {
+			e.parseFailed(&ParseError{"cmswinfo.html", -1, -1, -1, parseReasonValue, err})
+		} else {
+			ch <- prometheus.MustNewConstMetric(e.descs.downstreamBondingGroupSize, prometheus.GaugeValue, float64(size))
+		}
+	}
+
+	for desc, keys := range map[*prometheus.Desc][]string{
+		e.descs.provisionedMaxDownstream: {"Provisioned Max Downstream Rate", "Max Downstream Rate", "Downstream Max Traffic Rate"},
+		e.descs.provisionedMaxUpstream:   {"Provisioned Max Upstream Rate", "Max Upstream Rate", "Upstream Max Traffic Rate"},
+	} {
+		if value, ok := lookupKV(kv, keys...); ok && strings.ContainsAny(value, "0123456789") {
+			bps, err := parseBitrate(value)
+			if err != nil {
+				e.parseFailed(&ParseError{"cmswinfo.html", -1, -1, -1, parseReasonValue, err})
+			} else {
+				ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, bps)
+			}
+		}
+	}
+
+	// Fields not reported are left empty, which Prometheus treats like a
+	// missing label.
+	if e.options.DeviceInfo {
+		labelValues := []string{}
+		found := false
+		for _, l := range deviceInfoLabels {
+			value, ok := lookupKV(kv, l.keys...)
+			found = found || (ok && value != "")
+			labelValues = append(labelValues, value)
+		}
+		if found {
+			ch <- prometheus.MustNewConstMetric(e.descs.deviceInfo, prometheus.GaugeValue, 1, labelValues...)
+		}
+	}
+
+	// Without a lease the fields are blank or hold a placeholder like "N/A".
+	for desc, keys := range map[*prometheus.Desc][]string{
+		e.descs.cmDHCPLease:          {"DHCP Lease Time", "Lease Time"},
+		e.descs.cmDHCPLeaseRemaining: {"DHCP Lease Time Remaining", "Lease Time Remaining", "Remaining Lease Time"},
+	} {
+		if value, ok := lookupKV(kv, keys...); ok && strings.ContainsAny(value, "0123456789") {
+			seconds, err := parseDuration(value)
+			if err != nil {
+				e.parseFailed(&ParseError{"cmswinfo.html", -1, -1, -1, parseReasonValue, err})
+			} else {
+				ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, seconds)
+			}
+		}
+	}
+}
+
+// setFirmware rebuilds the descriptors with the firmware label if the
+// firmware version changed.
+func (e *Exporter) setFirmware(version string) {
+	if version == e.firmware {
+		return
+	}
+	log.Infoln("Firmware version is", version)
+	e.firmware = version
+	e.descs = newDescriptors(e.options.Namespace, e.options.Subsystems, e.constLabels())
+	if e.options.Config != nil {
+		e.configPages = newConfigPages(e.options.Config, e.options.Namespace, e.constLabels())
+	}
+}
+
+// constLabels returns the labels added to all TC4400 metrics.
+func (e *Exporter) constLabels() prometheus.Labels {
+	if !e.options.FirmwareLabel {
+		return nil
+	}
+	return prometheus.Labels{"firmware": e.firmware}
+}
+
+// lookupKV returns the value of the first of keys present in kv, ignoring
+// case.
+func lookupKV(kv map[string]string, keys ...string) (string, bool) {
+	for _, key := range keys {
+		for k, v := range kv {
+			if strings.EqualFold(k, key) {
+				return v, true
+			}
+		}
+	}
+	return "", false
+}
+
+// parseIP returns the normalized address of an IP or CIDR string, or an empty
+// string for blank, unspecified or invalid addresses.
+func parseIP(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, '/'); i >= 0 {
+		s = s[:i]
+	}
+	ip := net.ParseIP(s)
+	if ip == nil || ip.IsUnspecified() {
+		return ""
+	}
+	return ip.String()
+}
+
+// hostname matches a host name of dot separated RFC 1123 labels.
+var hostname = regexp.MustCompile(`^(?i)[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?(\.[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?)*\.?$`)
+
+// parseServer returns the normalized IP address or lower case host name of a
+// server, or an empty string for blank or unspecified addresses.
+func parseServer(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", nil
+	}
+	if net.ParseIP(s) != nil {
+		return parseIP(s), nil
+	}
+	if len(s) > 253 || !hostname.MatchString(s) {
+		return "", fmt.Errorf("Invalid server %q", s)
+	}
+	return strings.ToLower(strings.TrimSuffix(s, ".")), nil
+}
+
+// parseUpdatePending returns 1 if a firmware update status like "Pending",
+// "Available" or "Downloading" indicates an update, 0 for statuses like
+// "None" or "Up to date".
+func parseUpdatePending(s string) (float64, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "pending", "available", "yes", "true", "downloading", "download in progress", "in progress", "scheduled", "ready to install":
+		return 1, nil
+	case "none", "no", "false", "idle", "up to date", "up-to-date", "not available", "complete", "completed", "n/a":
+		return 0, nil
+	}
+	return 0, fmt.Errorf("Unknown firmware update status %q", s)
+}
+
+// parseTemperature parses a temperature such as "45 C" or "113 °F" and
+// returns it in degrees Celsius.
+func parseTemperature(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	fahrenheit := false
+	switch {
+	case strings.HasSuffix(s, "F"):
+		fahrenheit = true
+		s = strings.TrimSuffix(s, "F")
+	case strings.HasSuffix(s, "C"):
+		s = strings.TrimSuffix(s, "C")
+	default:
+		return 0, fmt.Errorf("Invalid temperature %q", s)
+	}
+	s = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(s), "\u00b0"))
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if fahrenheit {
+		value = (value - 32) * 5 / 9
+	}
+	return value, nil
+}
+
+// bitrateUnits are the factors of the bit rate units accepted by
+// parseBitrate, keyed in lower case.
+var bitrateUnits = map[string]float64{
+	"bps": 1, "bit/s": 1,
+	"kbps": 1e3, "kbit/s": 1e3,
+	"mbps": 1e6, "mbit/s": 1e6,
+	"gbps": 1e9, "gbit/s": 1e9,
+}
+
+// parseBitrate parses a bit rate like "100 Mbps" or "5000 Kbps" and returns
+// it in bits per second.
+func parseBitrate(s string) (float64, error) {
+	number, unit := splitValueUnit(s)
+	factor, ok := bitrateUnits[strings.ToLower(unit)]
+	if !ok || number == "" {
+		return 0, fmt.Errorf("Invalid bit rate %q", s)
+	}
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, err
+	}
+	return value * factor, nil
+}
+
+var (
+	durationClock = regexp.MustCompile(`(\d+):(\d{2}):(\d{2})`)
+	durationPart  = regexp.MustCompile(`(\d+)\s*([a-zA-Z]+)`)
+	durationUnits = map[string]float64{
+		"d": 86400, "day": 86400, "days": 86400,
+		"h": 3600, "hour": 3600, "hours": 3600,
+		"m": 60, "min": 60, "mins": 60, "minute": 60, "minutes": 60,
+		"s": 1, "sec": 1, "secs": 1, "second": 1, "seconds": 1,
+	}
+)
+
+// parseDuration parses a duration as shown by TC4400, like "3 days
+// 04h:05m:06s", "1 day 02:03:04" or "86400 s", and returns it in seconds.
+func parseDuration(s string) (float64, error) {
+	seconds := 0.0
+	matched := false
+	if m := durationClock.FindStringSubmatch(s); m != nil {
+		for i, factor := range []float64{3600, 60, 1} {
+			value, _ := strconv.ParseFloat(m[i+1], 64)
+			seconds += value * factor
+		}
+		s = strings.Replace(s, m[0], "", 1)
+		matched = true
+	}
+	for _, m := range durationPart.FindAllStringSubmatch(s, -1) {
+		factor, ok := durationUnits[strings.ToLower(m[2])]
+		if !ok {
+			return 0, fmt.Errorf("Invalid duration unit %q", m[2])
+		}
+		value, _ := strconv.ParseFloat(m[1], 64)
+		seconds += value * factor
+		matched = true
+	}
+	if !matched {
+		return 0, fmt.Errorf("Invalid duration %q", s)
+	}
+	return seconds, nil
+}
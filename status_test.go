@@ -0,0 +1,527 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// scrapeStatus scrapes a fake TC4400 serving a cmswinfo.html page with the
+// device status collector enabled and the options changed by tweak, if not
+// nil.
+func scrapeStatus(t *testing.T, page string, tweak func(*Options)) map[string]float64 {
+	t.Helper()
+	return scrapeModem(t, map[string]string{"cmswinfo.html": page}, func(o *Options) {
+		o.CollectCMSWInfo = true
+		if tweak != nil {
+			tweak(o)
+		}
+	})
+}
+
+// statusPage returns a cmswinfo.html page of key/value pairs.
+func statusPage(kv ...[]string) string {
+	return htmlPage(htmlTable("Status", []string{"Name", "Value"}, kv...))
+}
+
+func TestParseIP(t *testing.T) {
+	for s, expected := range map[string]string{
+		"10.12.34.56":     "10.12.34.56",
+		" 10.12.34.56/22": "10.12.34.56",
+		"2001:DB8:0:0::1": "2001:db8::1",
+		"2001:db8::1/64":  "2001:db8::1",
+		"0.0.0.0":         "",
+		"::":              "",
+		"":                "",
+		"---":             "",
+		"10.12.34":        "",
+	} {
+		if got := parseIP(s); got != expected {
+			t.Errorf("%q: expected %q, got %q", s, expected, got)
+		}
+	}
+}
+
+func TestCMIPInfo(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		page     string
+		expected map[string]float64
+	}{
+		{"dual stack", readTestdata(t, "cmswinfo.html"), map[string]float64{
+			`tc4400_cm_ip_info{family="ipv4",ip="10.12.34.56"}`: 1,
+			`tc4400_cm_ip_info{family="ipv6",ip="2001:db8::1"}`: 1,
+		}},
+		{"ipv4 only", statusPage([]string{"Cable Modem IP Address", "192.168.178.2"}, []string{"Cable Modem IPv6 Address", ""}), map[string]float64{
+			`tc4400_cm_ip_info{family="ipv4",ip="192.168.178.2"}`: 1,
+		}},
+		{"not provisioned", statusPage([]string{"Cable Modem IPv4 Address", "0.0.0.0"}, []string{"Cable Modem IPv6 Address", "::"}), map[string]float64{}},
+		{"blank", statusPage([]string{"Cable Modem IPv4 Address", ""}), map[string]float64{}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			samples := scrapeStatus(t, test.page, nil)
+			if got := withPrefix(samples, "tc4400_cm_ip_info"); fmt.Sprint(got) != fmt.Sprint(test.expected) {
+				t.Errorf("Expected %v, got %v", test.expected, got)
+			}
+			if failures := withPrefix(samples, "tc4400_exporter_parse_errors_total"); len(failures) != 0 {
+				t.Errorf("Expected no parse errors, got %v", failures)
+			}
+		})
+	}
+
+	// cmswinfo.html isn't fetched by default.
+	m := newModem(t, map[string]string{"cmswinfo.html": readTestdata(t, "cmswinfo.html")})
+	if got := withPrefix(series(t, newTestExporter(t, m.URL, defaultOptions())), "tc4400_cm_ip_info"); len(got) != 0 {
+		t.Errorf("Expected no IP info by default, got %v", got)
+	}
+	if requests := m.requestCount("cmswinfo.html"); requests != 0 {
+		t.Errorf("Expected cmswinfo.html not to be fetched by default, got %d requests", requests)
+	}
+}
+
+func TestParseTemperature(t *testing.T) {
+	for _, test := range []struct {
+		s        string
+		expected float64
+		valid    bool
+	}{
+		{"45 C", 45, true},
+		{"45C", 45, true},
+		{"45.5 °C", 45.5, true},
+		{" 113 F ", 45, true},
+		{"113°F", 45, true},
+		{"-4 F", -20, true},
+		{"45", 0, false},
+		{"hot C", 0, false},
+		{"", 0, false},
+	} {
+		got, err := parseTemperature(test.s)
+		if (err == nil) != test.valid {
+			t.Errorf("%q: expected valid %v, got error %v", test.s, test.valid, err)
+		}
+		if test.valid && got != test.expected {
+			t.Errorf("%q: expected %g, got %g", test.s, test.expected, got)
+		}
+	}
+}
+
+func TestDeviceTemperature(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		page     string
+		expected map[string]float64
+		failures int
+	}{
+		{"celsius", readTestdata(t, "cmswinfo.html"), map[string]float64{"tc4400_device_temperature_celsius": 45.5}, 0},
+		{"fahrenheit", statusPage([]string{"Temperature", "113 °F"}), map[string]float64{"tc4400_device_temperature_celsius": 45}, 0},
+		{"missing", statusPage([]string{"Model Name", "TC4400"}), map[string]float64{}, 0},
+		{"blank", statusPage([]string{"Temperature", ""}), map[string]float64{}, 0},
+		{"invalid", statusPage([]string{"Temperature", "hot"}), map[string]float64{}, 1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			samples := scrapeStatus(t, test.page, nil)
+			if got := withPrefix(samples, "tc4400_device_temperature_celsius"); fmt.Sprint(got) != fmt.Sprint(test.expected) {
+				t.Errorf("Expected %v, got %v", test.expected, got)
+			}
+			if failures := samples[`tc4400_exporter_parse_errors_total{file="cmswinfo.html",reason="value"}`]; failures != float64(test.failures) {
+				t.Errorf("Expected %d parse errors, got %g", test.failures, failures)
+			}
+		})
+	}
+}
+
+func TestFirmwareLabel(t *testing.T) {
+	pages := map[string]string{
+		"cmconnectionstatus.html": string(fixturePage),
+		"statsifc.html":           readTestdata(t, "statsifc.html"),
+		"cmswinfo.html":           readTestdata(t, "cmswinfo.html"),
+	}
+	for _, test := range []struct {
+		name     string
+		enabled  bool
+		firmware string
+	}{
+		{"disabled", false, ""},
+		{"enabled", true, "SR70.12.42-190604"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got := scrapeModem(t, pages, func(o *Options) { o.FirmwareLabel = test.enabled })
+			for _, name := range []string{
+				"tc4400_downstream_locked",
+				"tc4400_upstream_transmit_level_dbmv",
+				"tc4400_network_receive_bytes_total",
+				"tc4400_up",
+			} {
+				samples := withPrefix(got, name)
+				if len(samples) == 0 {
+					t.Errorf("Expected %s, got none", name)
+				}
+				for key := range samples {
+					if labeled := strings.Contains(key, fmt.Sprintf("firmware=%q", test.firmware)); labeled != test.enabled {
+						t.Errorf("Expected firmware label %t on %s", test.enabled, key)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestFirmwareLabelUpdate(t *testing.T) {
+	info := readTestdata(t, "cmswinfo.html")
+	m := newModem(t, map[string]string{
+		"cmconnectionstatus.html": string(fixturePage),
+		"cmswinfo.html":           info,
+	})
+	options := defaultOptions()
+	options.FirmwareLabel = true
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(newTestExporter(t, m.URL, options))
+
+	for _, firmware := range []string{"SR70.12.42-190604", "SR70.12.43-200101"} {
+		m.setPage("cmswinfo.html", strings.Replace(info, "SR70.12.42-190604", firmware, 1))
+		got := withPrefix(gatherSeries(t, registry), "tc4400_downstream_locked{")
+		if len(got) == 0 {
+			t.Fatalf("Expected tc4400_downstream_locked, got none")
+		}
+		for key := range got {
+			if !strings.Contains(key, fmt.Sprintf("firmware=%q", firmware)) {
+				t.Errorf("Expected firmware %s on %s", firmware, key)
+			}
+		}
+	}
+}
+
+func TestParseDurationString(t *testing.T) {
+	for _, test := range []struct {
+		s        string
+		expected float64
+		valid    bool
+	}{
+		{"7 days 00h:00m:00s", 604800, true},
+		{"3 days 04h:05m:06s", 273906, true},
+		{"1 day 02:03:04", 93784, true},
+		{"00h:10m:00s", 600, true},
+		{"86400 s", 86400, true},
+		{"2 hours 30 minutes", 9000, true},
+		{"", 0, false},
+		{"N/A", 0, false},
+		{"3 fortnights", 0, false},
+	} {
+		got, err := parseDuration(test.s)
+		if (err == nil) != test.valid {
+			t.Errorf("%q: expected valid %t, got error %v", test.s, test.valid, err)
+		}
+		if test.valid && got != test.expected {
+			t.Errorf("%q: expected %g seconds, got %g", test.s, test.expected, got)
+		}
+	}
+}
+
+func TestDHCPLease(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		page     string
+		expected map[string]float64
+	}{
+		{"lease", readTestdata(t, "cmswinfo.html"), map[string]float64{
+			"tc4400_cm_dhcp_lease_seconds":           604800,
+			"tc4400_cm_dhcp_lease_remaining_seconds": 273906,
+		}},
+		{"alternative keys", statusPage([]string{"Lease Time", "1 day 00:00:00"}, []string{"Remaining Lease Time", "86400 s"}), map[string]float64{
+			"tc4400_cm_dhcp_lease_seconds":           86400,
+			"tc4400_cm_dhcp_lease_remaining_seconds": 86400,
+		}},
+		{"not provisioned", statusPage([]string{"DHCP Lease Time", "N/A"}, []string{"DHCP Lease Time Remaining", ""}), map[string]float64{}},
+		{"absent", statusPage([]string{"Model Name", "TC4400"}), map[string]float64{}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := withPrefix(scrapeStatus(t, test.page, nil), "tc4400_cm_dhcp_lease"); !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("Expected %v, got %v", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestSystemResets(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		page     string
+		expected map[string]float64
+	}{
+		{"fixture", readTestdata(t, "cmswinfo.html"), map[string]float64{"tc4400_system_resets_total": 1024}},
+		{"reset count", statusPage([]string{"Reset Count", "7"}), map[string]float64{"tc4400_system_resets_total": 7}},
+		{"absent", statusPage([]string{"Model Name", "TC4400"}), map[string]float64{}},
+		{"blank", statusPage([]string{"Reboot Count", ""}), map[string]float64{}},
+		{"unparsable", statusPage([]string{"Reboot Count", "many"}), map[string]float64{}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := withPrefix(scrapeStatus(t, test.page, nil), "tc4400_system_resets_total"); !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("Expected %v, got %v", test.expected, got)
+			}
+		})
+	}
+
+	// A decreasing count is exported as reported, e.g. after a factory
+	// reset.
+	m := newModem(t, nil)
+	options := defaultOptions()
+	options.CollectCMSWInfo = true
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(newTestExporter(t, m.URL, options))
+	for _, count := range []float64{5, 6, 6, 2} {
+		m.setPage("cmswinfo.html", statusPage([]string{"Reboot Count", fmt.Sprint(count)}))
+		if got := gatherSeries(t, registry)["tc4400_system_resets_total"]; got != count {
+			t.Errorf("Expected %g resets, got %g", count, got)
+		}
+	}
+}
+
+func TestDeviceInfo(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		page     string
+		expected map[string]float64
+	}{
+		{"fixture", readTestdata(t, "cmswinfo.html"), map[string]float64{
+			`tc4400_device_info{boot_version="S1TC-3.63.20.104",hw_version="TC4400 Rev:3.6.0",mac="00:11:22:33:44:55",model="TC4400",serial="CP1234SA0XX",vendor="Technicolor"}`: 1,
+		}},
+		// Fields not reported are empty, i.e. missing labels.
+		{"partial", statusPage([]string{"Manufacturer", "Technicolor"}, []string{"Model Number", "TC4400"}, []string{"MAC Address", "00:11:22:33:44:55"}), map[string]float64{
+			`tc4400_device_info{boot_version="",hw_version="",mac="00:11:22:33:44:55",model="TC4400",serial="",vendor="Technicolor"}`: 1,
+		}},
+		{"none", statusPage([]string{"Temperature", "45.5 °C"}), map[string]float64{}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			// The device info alone fetches cmswinfo.html as well.
+			got := scrapeModem(t, map[string]string{"cmswinfo.html": test.page}, func(o *Options) { o.DeviceInfo = true })
+			if info := withPrefix(got, "tc4400_device_info"); !reflect.DeepEqual(info, test.expected) {
+				t.Errorf("Expected %v, got %v", test.expected, info)
+			}
+		})
+	}
+
+	got := scrapeStatus(t, readTestdata(t, "cmswinfo.html"), nil)
+	if info := withPrefix(got, "tc4400_device_info"); len(info) != 0 {
+		t.Errorf("Expected no device info unless enabled, got %v", info)
+	}
+}
+
+func TestBondingGroup(t *testing.T) {
+	partlyBonded := downstreamRow(3)
+	partlyBonded[4] = "Not Bonded"
+	channels := connectionStatusPage([][]string{downstreamRow(1), downstreamRow(2), partlyBonded}, [][]string{upstreamRow})
+	for _, test := range []struct {
+		name             string
+		cmswinfo         string
+		size             float64
+		valid            bool
+		parseErrorsTotal float64
+	}{
+		{"fixture", readTestdata(t, "cmswinfo.html"), 32, true, 0},
+		{"supported channels", statusPage([]string{"Downstream Channels Supported", "24"}), 24, true, 0},
+		{"not reported", statusPage([]string{"Model Name", "TC4400"}), 0, false, 0},
+		{"empty", statusPage([]string{"Bonding Group Size", ""}), 0, false, 0},
+		{"invalid", statusPage([]string{"Bonding Group Size", "many"}), 0, false, 1},
+	} {
+		got := scrapeModem(t, map[string]string{"cmconnectionstatus.html": channels, "cmswinfo.html": test.cmswinfo}, func(o *Options) { o.CollectCMSWInfo = true })
+		if value := got["tc4400_downstream_bonded_active"]; value != 2 {
+			t.Errorf("%s: expected 2 bonded channels, got %g", test.name, value)
+		}
+		if value, ok := got["tc4400_downstream_bonding_group_size"]; ok != test.valid || value != test.size {
+			t.Errorf("%s: expected a group size of %g (present: %t), got %g (present: %t)", test.name, test.size, test.valid, value, ok)
+		}
+		if value := got[`tc4400_exporter_parse_errors_total{file="cmswinfo.html",reason="value"}`]; value != test.parseErrorsTotal {
+			t.Errorf("%s: expected %g parse errors, got %g", test.name, test.parseErrorsTotal, value)
+		}
+	}
+}
+
+func TestParseBitrate(t *testing.T) {
+	for _, test := range []struct {
+		s        string
+		expected float64
+		valid    bool
+	}{
+		{"1000 Mbps", 1e9, true},
+		{"1000Mbps", 1e9, true},
+		{"50000 kbps", 5e7, true},
+		{"50000 Kbps", 5e7, true},
+		{"1.5 Gbps", 1.5e9, true},
+		{"512 bps", 512, true},
+		{"100 Mbit/s", 1e8, true},
+		{"250 kbit/s", 2.5e5, true},
+		{"1000", 0, false},
+		{"1000 MB", 0, false},
+		{"Mbps", 0, false},
+		{"fast Mbps", 0, false},
+		{"", 0, false},
+	} {
+		got, err := parseBitrate(test.s)
+		if (err == nil) != test.valid {
+			t.Errorf("%q: expected valid %t, got error %v", test.s, test.valid, err)
+		}
+		if test.valid && got != test.expected {
+			t.Errorf("%q: expected %g, got %g", test.s, test.expected, got)
+		}
+	}
+}
+
+func TestProvisionedRates(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		page        string
+		expected    map[string]float64
+		parseErrors float64
+	}{
+		{"fixture", readTestdata(t, "cmswinfo.html"), map[string]float64{
+			"tc4400_provisioned_max_downstream_bps": 1e9,
+			"tc4400_provisioned_max_upstream_bps":   5e7,
+		}, 0},
+		{"other keys", statusPage([]string{"Downstream Max Traffic Rate", "250 Mbit/s"}, []string{"Max Upstream Rate", "2.5 Mbps"}), map[string]float64{
+			"tc4400_provisioned_max_downstream_bps": 2.5e8,
+			"tc4400_provisioned_max_upstream_bps":   2.5e6,
+		}, 0},
+		{"downstream only", statusPage([]string{"Provisioned Max Downstream Rate", "100 Mbps"}), map[string]float64{
+			"tc4400_provisioned_max_downstream_bps": 1e8,
+		}, 0},
+		{"not reported", statusPage([]string{"Provisioned Max Downstream Rate", "N/A"}, []string{"Provisioned Max Upstream Rate", "---"}), map[string]float64{}, 0},
+		{"invalid unit", statusPage([]string{"Provisioned Max Downstream Rate", "100 MB"}), map[string]float64{}, 1},
+	} {
+		got := scrapeStatus(t, test.page, nil)
+		if rates := withPrefix(got, "tc4400_provisioned_max_"); !reflect.DeepEqual(rates, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, rates)
+		}
+		if errors := got[`tc4400_exporter_parse_errors_total{file="cmswinfo.html",reason="value"}`]; errors != test.parseErrors {
+			t.Errorf("%s: expected %g parse errors, got %g", test.name, test.parseErrors, errors)
+		}
+	}
+}
+
+func TestParseServer(t *testing.T) {
+	for _, test := range []struct {
+		s, expected string
+		valid       bool
+	}{
+		{"10.0.0.1", "10.0.0.1", true},
+		{" 10.0.0.1 ", "10.0.0.1", true},
+		{"2001:DB8::1", "2001:db8::1", true},
+		{"tftp.example.com", "tftp.example.com", true},
+		{"TFTP.Example.COM.", "tftp.example.com", true},
+		{"provisioning-01", "provisioning-01", true},
+		{"0.0.0.0", "", true},
+		{"", "", true},
+		{"tftp://10.0.0.1/boot.cfg", "", false},
+		{"10.0.0.1 (backup)", "", false},
+		{"-tftp.example.com", "", false},
+		{strings.Repeat("a.", 127) + "com", "", false},
+	} {
+		got, err := parseServer(test.s)
+		if (err == nil) != test.valid {
+			t.Errorf("%q: expected valid %t, got error %v", test.s, test.valid, err)
+		}
+		if got != test.expected {
+			t.Errorf("%q: expected %q, got %q", test.s, test.expected, got)
+		}
+	}
+}
+
+func TestTFTPServer(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		page        string
+		expected    map[string]float64
+		parseErrors float64
+	}{
+		{"fixture", readTestdata(t, "cmswinfo.html"), map[string]float64{`tc4400_cm_tftp_server_info{server="10.0.0.1"}`: 1}, 0},
+		{"hostname", statusPage([]string{"Boot File TFTP Server", "TFTP.Example.com"}), map[string]float64{`tc4400_cm_tftp_server_info{server="tftp.example.com"}`: 1}, 0},
+		{"absent", statusPage([]string{"Model Name", "TC4400"}), map[string]float64{}, 0},
+		{"blank", statusPage([]string{"TFTP Server", ""}), map[string]float64{}, 0},
+		{"not available", statusPage([]string{"TFTP Server", "N/A"}), map[string]float64{}, 0},
+		{"unspecified", statusPage([]string{"TFTP Server", "0.0.0.0"}), map[string]float64{}, 0},
+		{"invalid", statusPage([]string{"TFTP Server", "tftp://10.0.0.1/"}), map[string]float64{}, 1},
+	} {
+		got := scrapeStatus(t, test.page, nil)
+		if servers := withPrefix(got, "tc4400_cm_tftp_server_info"); !reflect.DeepEqual(servers, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, servers)
+		}
+		if errors := got[`tc4400_exporter_parse_errors_total{file="cmswinfo.html",reason="value"}`]; errors != test.parseErrors {
+			t.Errorf("%s: expected %g parse errors, got %g", test.name, test.parseErrors, errors)
+		}
+	}
+}
+
+func TestParseUpdatePending(t *testing.T) {
+	for _, test := range []struct {
+		s        string
+		expected float64
+		valid    bool
+	}{
+		{"Pending", 1, true},
+		{" Available ", 1, true},
+		{"Download in progress", 1, true},
+		{"Ready to install", 1, true},
+		{"Up to date", 0, true},
+		{"None", 0, true},
+		{"N/A", 0, true},
+		{"Completed", 0, true},
+		{"Maybe", 0, false},
+	} {
+		got, err := parseUpdatePending(test.s)
+		if (err == nil) != test.valid || got != test.expected {
+			t.Errorf("%q: expected %g (valid %t), got %g (error %v)", test.s, test.expected, test.valid, got, err)
+		}
+	}
+}
+
+func TestFirmwareUpdatePending(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		page        string
+		expected    map[string]float64
+		parseErrors float64
+	}{
+		{"up to date", readTestdata(t, "cmswinfo.html"), map[string]float64{"tc4400_firmware_update_pending": 0}, 0},
+		{"pending", readTestdata(t, "firmware-update.html"), map[string]float64{"tc4400_firmware_update_pending": 1}, 0},
+		{"software update", statusPage([]string{"Software Update", "Available"}), map[string]float64{"tc4400_firmware_update_pending": 1}, 0},
+		{"absent", statusPage([]string{"Model Name", "TC4400"}), map[string]float64{}, 0},
+		{"blank", statusPage([]string{"Firmware Update Status", ""}), map[string]float64{}, 0},
+		{"unknown", statusPage([]string{"Firmware Update Status", "Maybe"}), map[string]float64{}, 1},
+	} {
+		got := scrapeStatus(t, test.page, nil)
+		if pending := withPrefix(got, "tc4400_firmware_update_pending"); !reflect.DeepEqual(pending, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, pending)
+		}
+		if errors := got[`tc4400_exporter_parse_errors_total{file="cmswinfo.html",reason="value"}`]; errors != test.parseErrors {
+			t.Errorf("%s: expected %g parse errors, got %g", test.name, test.parseErrors, errors)
+		}
+	}
+}
+
+func TestCPECount(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		page        string
+		expected    map[string]float64
+		parseErrors float64
+	}{
+		{"fixture", readTestdata(t, "cmswinfo.html"), map[string]float64{"tc4400_cm_cpe_count": 3}, 0},
+		{"attached devices", statusPage([]string{"Attached Devices", "12"}), map[string]float64{"tc4400_cm_cpe_count": 12}, 0},
+		{"none", statusPage([]string{"CPE Count", "0"}), map[string]float64{"tc4400_cm_cpe_count": 0}, 0},
+		{"absent", statusPage([]string{"Model Name", "TC4400"}), map[string]float64{}, 0},
+		{"blank", statusPage([]string{"Number of CPEs", ""}), map[string]float64{}, 0},
+		{"not reported", statusPage([]string{"Number of CPEs", "-"}), map[string]float64{}, 0},
+		{"negative", statusPage([]string{"Number of CPEs", "-1"}), map[string]float64{}, 1},
+		{"invalid", statusPage([]string{"Number of CPEs", "many"}), map[string]float64{}, 1},
+	} {
+		got := scrapeStatus(t, test.page, nil)
+		if count := withPrefix(got, "tc4400_cm_cpe_count"); !reflect.DeepEqual(count, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, count)
+		}
+		if errors := got[`tc4400_exporter_parse_errors_total{file="cmswinfo.html",reason="value"}`]; errors != test.parseErrors {
+			t.Errorf("%s: expected %g parse errors, got %g", test.name, test.parseErrors, errors)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/log"
+)
+
+// TextfileWriter periodically gathers metrics and writes them in the text
+// format to a file, e.g. for the node_exporter textfile collector.
+type TextfileWriter struct {
+	path     string
+	interval time.Duration
+	gatherer prometheus.Gatherer
+}
+
+func NewTextfileWriter(path string, interval time.Duration, gatherer prometheus.Gatherer) *TextfileWriter {
+	return &TextfileWriter{
+		path:     path,
+		interval: interval,
+		gatherer: gatherer,
+	}
+}
+
+// Run writes the file once per interval until the process exits.
+func (w *TextfileWriter) Run() {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := w.write(); err != nil {
+			log.Errorln("Writing", w.path, "failed:", err)
+		}
+		<-ticker.C
+	}
+}
+
+// write gathers the metrics and replaces the file atomically by writing to
+// a temporary file in the same directory first, so readers never see a
+// partially written file.
+func (w *TextfileWriter) write() error {
+	families, err := w.gatherer.Gather()
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	for _, family := range families {
+		if _, err := expfmt.MetricFamilyToText(&buf, family); err != nil {
+			return err
+		}
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(w.path), "."+filepath.Base(w.path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), w.path)
+}
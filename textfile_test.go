@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestTextfileWriter(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tc4400.prom")
+	m := newModem(t, map[string]string{"cmconnectionstatus.html": string(fixturePage)})
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(newTestExporter(t, m.URL, defaultOptions()))
+	w := NewTextfileWriter(path, time.Minute, registry)
+
+	// read parses the file and returns the number of downstream channels.
+	read := func() int {
+		t.Helper()
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		families, err := new(expfmt.TextParser).TextToMetricFamilies(f)
+		if err != nil {
+			t.Fatalf("Expected a valid textfile, got %v", err)
+		}
+		if families["tc4400_up"] == nil {
+			t.Errorf("Expected the exporter metrics, got %d families", len(families))
+		}
+		return len(families["tc4400_downstream_locked"].GetMetric())
+	}
+
+	if err := w.write(); err != nil {
+		t.Fatal(err)
+	}
+	if channels := read(); channels != 2 {
+		t.Errorf("Expected 2 channels, got %d", channels)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0644 {
+		t.Errorf("Expected mode 0644, got %o", mode)
+	}
+
+	// The file is replaced, not modified in place.
+	m.setPage("cmconnectionstatus.html", connectionStatusPage([][]string{downstreamRow(1)}, [][]string{upstreamRow}))
+	if err := w.write(); err != nil {
+		t.Fatal(err)
+	}
+	if channels := read(); channels != 1 {
+		t.Errorf("Expected 1 channel, got %d", channels)
+	}
+	if newInfo, err := os.Stat(path); err != nil || os.SameFile(info, newInfo) {
+		t.Errorf("Expected a new file, got error %v", err)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("Expected only the textfile, got %d files", len(files))
+	}
+}
+
+func TestTextfileWriterFailure(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tc4400.prom")
+	if err := ioutil.WriteFile(path, []byte("previous\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed gather leaves the previous file.
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectorFunc(func(ch chan<- prometheus.Metric) {
+		ch <- prometheus.NewInvalidMetric(prometheus.NewDesc("failing", "Failing metric.", nil, nil), errors.New("failed"))
+	}))
+	if err := NewTextfileWriter(path, time.Minute, registry).write(); err == nil {
+		t.Error("Expected an error for a failed gather")
+	}
+	if content, err := ioutil.ReadFile(path); err != nil || string(content) != "previous\n" {
+		t.Errorf("Expected the previous file, got %q (error %v)", content, err)
+	}
+
+	// So does a directory that can't be written to.
+	missing := filepath.Join(dir, "missing", "tc4400.prom")
+	if err := NewTextfileWriter(missing, time.Minute, prometheus.NewRegistry()).write(); err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Errorf("Expected an error for a missing directory, got %v", err)
+	}
+}